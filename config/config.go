@@ -0,0 +1,355 @@
+// Package config loads the settings of lazy-spots. Every setting has a
+// default which is overridden, in this order, by the JSON configuration file,
+// by environment variables and by command line flags.
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/IcoBoyanov/lazy-spots/repository/miniocli"
+)
+
+// Environment variables, secrets are best passed this way rather than as flags
+const (
+	ConfigFileEnv     = "LAZY_SPOTS_CONFIG"
+	ClientIDEnv       = "CLIENT_ID"
+	ClientSecretEnv   = "CLIENT_SECRET"
+	SessionSecretEnv  = "SESSION_SECRET"
+	WebhookTokenEnv   = "WEBHOOK_VERIFY_TOKEN"
+	PublicURLEnv      = "PUBLIC_URL"
+	StorageEnv        = "STORAGE"
+	MinioEndpointEnv  = "MINIO_ENDPOINT"
+	MinioAccessKeyEnv = "MINIO_ACCESS_KEY"
+	MinioSecretEnv    = "MINIO_SECRET"
+)
+
+// Storage backends
+const (
+	StorageMinio  = "minio"
+	StorageFS     = "fs"
+	StorageBolt   = "bolt"
+	StorageMemory = "memory"
+)
+
+type Config struct {
+	// Listen is the address the server listens on
+	Listen string `json:"listen"`
+	// PublicURL is the address the server is reached at, the OAuth callback
+	// is PublicURL/callback. It defaults to http://localhost and the port of
+	// Listen.
+	PublicURL     string `json:"public_url"`
+	SessionSecret string `json:"session_secret"`
+
+	Strava  StravaConfig  `json:"strava"`
+	Storage StorageConfig `json:"storage"`
+	Collect CollectConfig `json:"collect"`
+	Spots   SpotsConfig   `json:"spots"`
+
+	regions *model.RegionFilter
+	args    []string
+}
+
+type StravaConfig struct {
+	ClientID           string `json:"client_id"`
+	ClientSecret       string `json:"client_secret"`
+	WebhookVerifyToken string `json:"webhook_verify_token"`
+	// WebhookSubscription is the id of the push subscription, events are
+	// refused without it
+	WebhookSubscription int64 `json:"webhook_subscription"`
+}
+
+type StorageConfig struct {
+	// Backend is one of minio, fs, bolt or memory
+	Backend string      `json:"backend"`
+	Dir     string      `json:"dir"`
+	File    string      `json:"file"`
+	Minio   MinioConfig `json:"minio"`
+}
+
+type MinioConfig struct {
+	Endpoint  string           `json:"endpoint"`
+	AccessKey string           `json:"access_key"`
+	Secret    string           `json:"secret"`
+	UseSSL    bool             `json:"use_ssl"`
+	Buckets   miniocli.Buckets `json:"buckets"`
+}
+
+type CollectConfig struct {
+	Workers int `json:"workers"`
+	// Regions is a JSON region filter file, all activities are collected
+	// without it
+	Regions string `json:"regions"`
+}
+
+type SpotsConfig struct {
+	MinStop       Duration `json:"min_stop"`
+	ClusterRadius float64  `json:"cluster_radius"`
+	Limit         int      `json:"limit"`
+}
+
+// Duration is a time.Duration written as "2m30s" in the configuration file
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"2m\": %v", err)
+	}
+	return d.Set(s)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// Default returns the configuration used when nothing is configured
+func Default() *Config {
+	return &Config{
+		Listen: ":8888",
+		Storage: StorageConfig{
+			Backend: StorageMinio,
+			Dir:     "data",
+			File:    "lazy-spots.db",
+			Minio: MinioConfig{
+				Endpoint: "172.17.0.2:9000",
+				Buckets:  miniocli.DefaultBuckets,
+			},
+		},
+		Collect: CollectConfig{
+			Workers: model.DefaultCollectWorkers,
+		},
+		Spots: SpotsConfig{
+			MinStop:       Duration{model.DefaultMinStopDuration},
+			ClusterRadius: model.DefaultClusterRadius,
+			Limit:         model.DefaultSpotsLimit,
+		},
+	}
+}
+
+// Load reads the configuration file given by the -config flag or the
+// LAZY_SPOTS_CONFIG variable, the environment and the flags in args, and
+// validates the result
+func Load(name string, args []string) (*Config, error) {
+	c := Default()
+	var file string
+	fs := c.flagSet(name, &file)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c.args = fs.Args()
+
+	// Flags are parsed into the defaults first, so their values are kept
+	// and applied again on top of the file and the environment
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	if file == "" {
+		file = os.Getenv(ConfigFileEnv)
+	}
+	if file != "" {
+		if err := c.loadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	c.loadEnv()
+	for name, value := range set {
+		fs.Set(name, value)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+	return c, nil
+}
+
+func (c *Config) flagSet(name string, file *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(file, "config", "", "JSON configuration file, settings of the file are overridden by the environment and by flags")
+	fs.StringVar(&c.Listen, "listen", c.Listen, "address the server listens on")
+	fs.StringVar(&c.PublicURL, "public-url", c.PublicURL, "address the server is reached at, defaults to http://localhost and the port of -listen")
+	fs.StringVar(&c.Storage.Backend, "storage", c.Storage.Backend, "storage backend, minio, fs, bolt or memory")
+	fs.StringVar(&c.Storage.Dir, "storage-dir", c.Storage.Dir, "root directory of the fs storage backend")
+	fs.StringVar(&c.Storage.File, "storage-file", c.Storage.File, "database file of the bolt storage backend")
+	fs.StringVar(&c.Storage.Minio.Endpoint, "minio-endpoint", c.Storage.Minio.Endpoint, "address of the minio server")
+	fs.BoolVar(&c.Storage.Minio.UseSSL, "minio-ssl", c.Storage.Minio.UseSSL, "connect to minio with TLS")
+	fs.IntVar(&c.Collect.Workers, "workers", c.Collect.Workers, "number of activities fetched concurrently")
+	fs.StringVar(&c.Collect.Regions, "regions", c.Collect.Regions, "JSON file with the regions activities are collected from, all activities are collected without it")
+	fs.Var(&c.Spots.MinStop, "min-stop", "shortest pause counted as a stop")
+	fs.Float64Var(&c.Spots.ClusterRadius, "cluster-radius", c.Spots.ClusterRadius, "distance in meters within which stops are merged into a spot")
+	fs.IntVar(&c.Spots.Limit, "spots-limit", c.Spots.Limit, "number of spots returned by /spots")
+	fs.Int64Var(&c.Strava.WebhookSubscription, "webhook-subscription", c.Strava.WebhookSubscription, "id of the strava push subscription, webhook events are only accepted from it")
+	return fs
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open configuration file: %v", err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("could not parse configuration file '%s': %v", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() {
+	vars := []struct {
+		name  string
+		value *string
+	}{
+		{ClientIDEnv, &c.Strava.ClientID},
+		{ClientSecretEnv, &c.Strava.ClientSecret},
+		{SessionSecretEnv, &c.SessionSecret},
+		{WebhookTokenEnv, &c.Strava.WebhookVerifyToken},
+		{PublicURLEnv, &c.PublicURL},
+		{StorageEnv, &c.Storage.Backend},
+		{MinioEndpointEnv, &c.Storage.Minio.Endpoint},
+		{MinioAccessKeyEnv, &c.Storage.Minio.AccessKey},
+		{MinioSecretEnv, &c.Storage.Minio.Secret},
+	}
+	for _, v := range vars {
+		if value, ok := os.LookupEnv(v.name); ok && value != "" {
+			*v.value = value
+		}
+	}
+}
+
+func (c *Config) validate() error {
+	if c.Listen == "" {
+		return fmt.Errorf("listen address is empty")
+	}
+	_, port, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen address '%s': %v", c.Listen, err)
+	}
+	if c.PublicURL == "" {
+		c.PublicURL = "http://localhost"
+		if port != "" {
+			c.PublicURL += ":" + port
+		}
+	}
+	u, err := url.Parse(c.PublicURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("public url '%s' must be an absolute http or https url", c.PublicURL)
+	}
+	c.PublicURL = strings.TrimSuffix(c.PublicURL, "/")
+
+	if c.Strava.WebhookSubscription != 0 && c.Strava.WebhookVerifyToken == "" {
+		return fmt.Errorf("webhook subscription is set without a verify token, set '%s' or strava.webhook_verify_token", WebhookTokenEnv)
+	}
+
+	if err := c.Storage.validate(); err != nil {
+		return err
+	}
+
+	if c.Collect.Workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", c.Collect.Workers)
+	}
+	if c.Collect.Regions != "" {
+		regions, err := model.LoadRegionFilter(c.Collect.Regions)
+		if err != nil {
+			return err
+		}
+		c.regions = regions
+	}
+
+	if c.Spots.MinStop.Duration <= 0 {
+		return fmt.Errorf("min stop must be positive, got %v", c.Spots.MinStop)
+	}
+	if c.Spots.ClusterRadius <= 0 {
+		return fmt.Errorf("cluster radius must be positive, got %v", c.Spots.ClusterRadius)
+	}
+	if c.Spots.Limit < 0 {
+		return fmt.Errorf("spots limit must not be negative, got %d", c.Spots.Limit)
+	}
+	return nil
+}
+
+func (s *StorageConfig) validate() error {
+	switch s.Backend {
+	case StorageMinio:
+		if s.Minio.Endpoint == "" {
+			return fmt.Errorf("minio endpoint is missing")
+		}
+		b := s.Minio.Buckets
+		seen := make(map[string]bool)
+		for _, name := range []string{b.Rides, b.Athletes, b.MapData, b.Sync, b.Tokens} {
+			if name == "" {
+				return fmt.Errorf("minio bucket names must not be empty")
+			}
+			if seen[name] {
+				return fmt.Errorf("minio bucket '%s' is used twice", name)
+			}
+			seen[name] = true
+		}
+	case StorageFS:
+		if s.Dir == "" {
+			return fmt.Errorf("storage directory is missing")
+		}
+	case StorageBolt:
+		if s.File == "" {
+			return fmt.Errorf("storage file is missing")
+		}
+	case StorageMemory:
+	default:
+		return fmt.Errorf("unknown storage backend '%s', expected minio, fs, bolt or memory", s.Backend)
+	}
+	return nil
+}
+
+// RequireStrava checks the Strava credentials, which only the server needs
+func (c *Config) RequireStrava() error {
+	if c.Strava.ClientID == "" {
+		return fmt.Errorf("invalid configuration: strava client id is missing, set '%s' or strava.client_id", ClientIDEnv)
+	}
+	if c.Strava.ClientSecret == "" {
+		return fmt.Errorf("invalid configuration: strava client secret is missing, set '%s' or strava.client_secret", ClientSecretEnv)
+	}
+	return nil
+}
+
+// Args are the arguments left after the flags
+func (c *Config) Args() []string {
+	return c.args
+}
+
+// CallbackURL is the OAuth redirect url registered with Strava
+func (c *Config) CallbackURL() string {
+	return c.PublicURL + "/callback"
+}
+
+// Regions returns the region filter loaded from Collect.Regions, nil when
+// activities are not filtered
+func (c *Config) Regions() *model.RegionFilter {
+	return c.regions
+}
+
+// StopDetector detects stops as configured
+func (c *Config) StopDetector() model.StopDetector {
+	return model.StopDetector{MinDuration: c.Spots.MinStop.Duration}
+}
@@ -0,0 +1,48 @@
+package config
+
+import "testing"
+
+func TestPublicURLDefault(t *testing.T) {
+	tests := []struct {
+		listen, want string
+	}{
+		{":8888", "http://localhost:8888"},
+		{"0.0.0.0:8888", "http://localhost:8888"},
+		{"127.0.0.1:80", "http://localhost:80"},
+		{"[::1]:9000", "http://localhost:9000"},
+		{":", "http://localhost"},
+	}
+	for _, tt := range tests {
+		c := Default()
+		c.Listen = tt.listen
+		if err := c.validate(); err != nil {
+			t.Errorf("listen %q: %v", tt.listen, err)
+			continue
+		}
+		if c.PublicURL != tt.want {
+			t.Errorf("listen %q: public url = %q, want %q", tt.listen, c.PublicURL, tt.want)
+		}
+	}
+}
+
+func TestInvalidListen(t *testing.T) {
+	for _, listen := range []string{"localhost", "0.0.0.0"} {
+		c := Default()
+		c.Listen = listen
+		if err := c.validate(); err == nil {
+			t.Errorf("listen %q: expected an error", listen)
+		}
+	}
+}
+
+func TestPublicURLIsKept(t *testing.T) {
+	c := Default()
+	c.Listen = "0.0.0.0:8888"
+	c.PublicURL = "https://spots.example.com/"
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if c.PublicURL != "https://spots.example.com" {
+		t.Errorf("public url = %q", c.PublicURL)
+	}
+}
@@ -0,0 +1,163 @@
+// Package geo tests points against areas such as city limits, parks or
+// training areas. Coordinates follow GeoJSON and are [lng, lat] pairs.
+package geo
+
+import (
+	"fmt"
+	"math"
+)
+
+const earthRadius = 6371000.0
+
+// MetersPerDegree is the length of a degree of latitude, and of longitude at
+// the equator
+const MetersPerDegree = earthRadius * math.Pi / 180
+
+// Geofence is an area points can be tested against
+type Geofence interface {
+	Contains(lat, lng float64) bool
+}
+
+// Bounded is a Geofence which knows its bounding box
+type Bounded interface {
+	Geofence
+	Bounds() BBox
+}
+
+// BBox is a bounding box [west, south, east, north]
+type BBox [4]float64
+
+func (b BBox) Contains(lat, lng float64) bool {
+	return lng >= b[0] && lat >= b[1] && lng <= b[2] && lat <= b[3]
+}
+
+func (b BBox) Bounds() BBox {
+	return b
+}
+
+// Center returns the [lat, lng] center of the box
+func (b BBox) Center() (lat float64, lng float64) {
+	return (b[1] + b[3]) / 2, (b[0] + b[2]) / 2
+}
+
+func (b BBox) extend(o BBox) BBox {
+	return BBox{math.Min(b[0], o[0]), math.Min(b[1], o[1]), math.Max(b[2], o[2]), math.Max(b[3], o[3])}
+}
+
+var emptyBBox = BBox{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+
+// Ring is a closed line of [lng, lat] points
+type Ring [][2]float64
+
+// Contains uses ray casting. Points on an edge may fall on either side, but a
+// point on an edge shared by two rings is inside exactly one of them.
+func (r Ring) Contains(lat, lng float64) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		xi, yi := r[i][0], r[i][1]
+		xj, yj := r[j][0], r[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func (r Ring) Bounds() BBox {
+	b := emptyBBox
+	for _, p := range r {
+		b = b.extend(BBox{p[0], p[1], p[0], p[1]})
+	}
+	return b
+}
+
+// Polygon is an outer ring followed by its holes
+type Polygon []Ring
+
+func (p Polygon) Contains(lat, lng float64) bool {
+	if len(p) == 0 || !p[0].Contains(lat, lng) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if hole.Contains(lat, lng) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p Polygon) Bounds() BBox {
+	if len(p) == 0 {
+		return emptyBBox
+	}
+	return p[0].Bounds()
+}
+
+// Validate checks that the polygon has rings of at least 4 positions which
+// end where they start
+func (p Polygon) Validate() error {
+	if len(p) == 0 {
+		return fmt.Errorf("polygon has no rings")
+	}
+	for _, ring := range p {
+		if len(ring) < 4 {
+			return fmt.Errorf("polygon ring has %d positions, expected at least 4", len(ring))
+		}
+		if ring[0] != ring[len(ring)-1] {
+			return fmt.Errorf("polygon ring is not closed, it ends at %v instead of %v", ring[len(ring)-1], ring[0])
+		}
+	}
+	return nil
+}
+
+type MultiPolygon []Polygon
+
+func (mp MultiPolygon) Contains(lat, lng float64) bool {
+	for _, p := range mp {
+		if p.Contains(lat, lng) {
+			return true
+		}
+	}
+	return false
+}
+
+func (mp MultiPolygon) Bounds() BBox {
+	b := emptyBBox
+	for _, p := range mp {
+		b = b.extend(p.Bounds())
+	}
+	return b
+}
+
+// Union contains the points inside any of its geofences
+type Union []Geofence
+
+func (u Union) Contains(lat, lng float64) bool {
+	for _, g := range u {
+		if g.Contains(lat, lng) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounds of the geofences which know theirs
+func (u Union) Bounds() BBox {
+	b := emptyBBox
+	for _, g := range u {
+		if bounded, ok := g.(Bounded); ok {
+			b = b.extend(bounded.Bounds())
+		}
+	}
+	return b
+}
+
+// Distance returns the great-circle distance between two points in meters
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
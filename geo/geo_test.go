@@ -0,0 +1,150 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+// square is a closed ring from west, south to east, north
+func square(west, south, east, north float64) Ring {
+	return Ring{{west, south}, {east, south}, {east, north}, {west, north}, {west, south}}
+}
+
+// A park with a pond in it and an island in the pond, which is a separate
+// polygon
+var (
+	park   = Polygon{square(0, 0, 10, 10), square(4, 4, 6, 6)}
+	island = Polygon{square(4.5, 4.5, 5.5, 5.5)}
+	forest = Polygon{Ring{{20, 0}, {30, 0}, {25, 10}, {20, 0}}}
+)
+
+type point struct {
+	name     string
+	lat, lng float64
+	want     bool
+}
+
+func checkContains(t *testing.T, fence Geofence, points []point) {
+	t.Helper()
+	for _, p := range points {
+		if got := fence.Contains(p.lat, p.lng); got != p.want {
+			t.Errorf("%s (%g, %g): contains = %v, want %v", p.name, p.lat, p.lng, got, p.want)
+		}
+	}
+}
+
+func TestPolygonContains(t *testing.T) {
+	checkContains(t, park, []point{
+		{"inside", 2, 2, true},
+		{"in the hole", 5, 5, false},
+		{"between the hole and the outer ring", 5, 8, true},
+		{"outside", 5, 12, false},
+		{"west of the park", 5, -1, false},
+		{"beyond a vertex", 11, 11, false},
+	})
+	checkContains(t, forest, []point{
+		{"inside the triangle", 2, 25, true},
+		{"inside the bounding box only", 9, 21, false},
+	})
+	checkContains(t, Polygon{}, []point{{"empty polygon", 0, 0, false}})
+}
+
+// Points on an edge are inside exactly one of the polygons sharing it, so
+// adjacent regions never both or neither contain a point
+func TestPolygonSharedEdges(t *testing.T) {
+	// A 2x2 grid of squares around 10, 10
+	var tiles []Polygon
+	for _, south := range []float64{0, 10} {
+		for _, west := range []float64{0, 10} {
+			tiles = append(tiles, Polygon{square(west, south, west+10, south+10)})
+		}
+	}
+	for _, p := range []point{
+		{name: "shared meridian", lat: 5, lng: 10},
+		{name: "shared parallel", lat: 10, lng: 5},
+		{name: "shared vertex", lat: 10, lng: 10},
+		{name: "inside a tile", lat: 15, lng: 15},
+	} {
+		inside := 0
+		for _, tile := range tiles {
+			if tile.Contains(p.lat, p.lng) {
+				inside++
+			}
+		}
+		if inside != 1 {
+			t.Errorf("%s (%g, %g) is in %d tiles, want 1", p.name, p.lat, p.lng, inside)
+		}
+	}
+}
+
+func TestMultiPolygonContains(t *testing.T) {
+	mp := MultiPolygon{park, island, forest}
+	checkContains(t, mp, []point{
+		{"park", 2, 2, true},
+		{"pond", 4.2, 4.2, false},
+		{"island in the pond", 5, 5, true},
+		{"forest", 2, 25, true},
+		{"between park and forest", 5, 15, false},
+	})
+	checkContains(t, MultiPolygon{}, []point{{"empty multipolygon", 0, 0, false}})
+
+	if b := mp.Bounds(); b != (BBox{0, 0, 30, 10}) {
+		t.Errorf("bounds = %v, want [0 0 30 10]", b)
+	}
+}
+
+func TestUnion(t *testing.T) {
+	// A Geofence which does not know its bounds
+	everywhereNorth := geofenceFunc(func(lat, lng float64) bool { return lat > 50 })
+	u := Union{park, BBox{-10, -10, -5, -5}, everywhereNorth}
+	checkContains(t, u, []point{
+		{"park", 2, 2, true},
+		{"pond", 5, 5, false},
+		{"box", -7, -7, true},
+		{"north", 60, 100, true},
+		{"nowhere", 20, 20, false},
+	})
+	checkContains(t, Union{}, []point{{"empty union", 0, 0, false}})
+
+	if b := u.Bounds(); b != (BBox{-10, -10, 10, 10}) {
+		t.Errorf("bounds = %v, want the bounds of the park and the box", b)
+	}
+}
+
+type geofenceFunc func(lat, lng float64) bool
+
+func (f geofenceFunc) Contains(lat, lng float64) bool { return f(lat, lng) }
+
+func TestBBox(t *testing.T) {
+	b := BBox{23.2, 42.6, 23.4, 42.8}
+	checkContains(t, b, []point{
+		{"inside", 42.7, 23.3, true},
+		{"south west corner", 42.6, 23.2, true},
+		{"north east corner", 42.8, 23.4, true},
+		{"north", 42.81, 23.3, false},
+		{"east", 42.7, 23.41, false},
+	})
+	if lat, lng := b.Center(); math.Abs(lat-42.7) > 1e-9 || math.Abs(lng-23.3) > 1e-9 {
+		t.Errorf("center = %g, %g, want 42.7, 23.3", lat, lng)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lng1, lat2, lng2 float64
+		want                   float64
+	}{
+		{"same point", 42.69, 23.32, 42.69, 23.32, 0},
+		{"degree of latitude", 0, 0, 1, 0, MetersPerDegree},
+		{"degree of longitude at the equator", 0, 0, 0, 1, MetersPerDegree},
+		{"degree of longitude at 60°", 60, 0, 60, 1, MetersPerDegree / 2},
+		{"across the antimeridian", 0, 179.5, 0, -179.5, MetersPerDegree},
+	}
+	for _, tt := range tests {
+		// haversine along a parallel is slightly shorter than the parallel
+		if got := Distance(tt.lat1, tt.lng1, tt.lat2, tt.lng2); math.Abs(got-tt.want) > 10 {
+			t.Errorf("%s: distance = %.1f, want %.1f", tt.name, got, tt.want)
+		}
+	}
+}
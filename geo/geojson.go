@@ -0,0 +1,125 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Geometries  []geoJSON       `json:"geometries"`
+	Features    []geoJSON       `json:"features"`
+}
+
+// LoadGeoJSON reads the polygons of a GeoJSON file
+func LoadGeoJSON(path string) (Bounded, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read geojson: %v", err)
+	}
+	return ParseGeoJSON(content)
+}
+
+// ParseGeoJSON reads a Polygon or MultiPolygon geometry, a GeometryCollection
+// or a Feature or FeatureCollection with such geometries. Several polygons
+// are returned as a MultiPolygon, other geometries are ignored.
+func ParseGeoJSON(content []byte) (Bounded, error) {
+	var object geoJSON
+	if err := json.Unmarshal(content, &object); err != nil {
+		return nil, fmt.Errorf("could not parse geojson: %v", err)
+	}
+
+	var polygons MultiPolygon
+	if err := collectPolygons(&object, &polygons); err != nil {
+		return nil, err
+	}
+	switch len(polygons) {
+	case 0:
+		return nil, fmt.Errorf("geojson has no polygons")
+	case 1:
+		return polygons[0], nil
+	default:
+		return polygons, nil
+	}
+}
+
+func collectPolygons(object *geoJSON, polygons *MultiPolygon) error {
+	switch object.Type {
+	case "Polygon":
+		var p Polygon
+		if err := json.Unmarshal(object.Coordinates, &p); err != nil {
+			return fmt.Errorf("invalid polygon: %v", err)
+		}
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		*polygons = append(*polygons, p)
+	case "MultiPolygon":
+		var mp MultiPolygon
+		if err := json.Unmarshal(object.Coordinates, &mp); err != nil {
+			return fmt.Errorf("invalid multipolygon: %v", err)
+		}
+		for _, p := range mp {
+			if err := p.Validate(); err != nil {
+				return err
+			}
+		}
+		*polygons = append(*polygons, mp...)
+	case "GeometryCollection":
+		for i := range object.Geometries {
+			if err := collectPolygons(&object.Geometries[i], polygons); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if object.Geometry != nil {
+			return collectPolygons(object.Geometry, polygons)
+		}
+	case "FeatureCollection":
+		for i := range object.Features {
+			if err := collectPolygons(&object.Features[i], polygons); err != nil {
+				return err
+			}
+		}
+	case "Point", "MultiPoint", "LineString", "MultiLineString":
+	default:
+		return fmt.Errorf("unknown geojson type '%s'", object.Type)
+	}
+	return nil
+}
+
+// FeatureCollection is a GeoJSON FeatureCollection of points
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON Feature with a Point geometry. Properties are marshaled
+// as the feature's properties object.
+type Feature struct {
+	Type       string      `json:"type"`
+	Geometry   Point       `json:"geometry"`
+	Properties interface{} `json:"properties"`
+}
+
+// Point is a GeoJSON Point, coordinates are [lng, lat]
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+func NewFeatureCollection() *FeatureCollection {
+	return &FeatureCollection{Type: "FeatureCollection", Features: make([]Feature, 0)}
+}
+
+// AddPoint adds a Point feature at lat, lng
+func (fc *FeatureCollection) AddPoint(lat, lng float64, properties interface{}) {
+	fc.Features = append(fc.Features, Feature{
+		Type:       "Feature",
+		Geometry:   Point{Type: "Point", Coordinates: [2]float64{lng, lat}},
+		Properties: properties,
+	})
+}
@@ -0,0 +1,131 @@
+package geo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	parkCoordinates   = `[[[0,0],[10,0],[10,10],[0,10],[0,0]],[[4,4],[6,4],[6,6],[4,6],[4,4]]]`
+	forestCoordinates = `[[[20,0],[30,0],[25,10],[20,0]]]`
+)
+
+func TestParseGeoJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		geojson string
+		points  []point
+	}{
+		{
+			name:    "Polygon",
+			geojson: `{"type":"Polygon","coordinates":` + parkCoordinates + `}`,
+			points:  []point{{"park", 2, 2, true}, {"pond", 5, 5, false}, {"forest", 2, 25, false}},
+		},
+		{
+			name:    "MultiPolygon",
+			geojson: `{"type":"MultiPolygon","coordinates":[` + parkCoordinates + `,` + forestCoordinates + `]}`,
+			points:  []point{{"park", 2, 2, true}, {"pond", 5, 5, false}, {"forest", 2, 25, true}},
+		},
+		{
+			name: "GeometryCollection",
+			geojson: `{"type":"GeometryCollection","geometries":[
+				{"type":"Point","coordinates":[5,5]},
+				{"type":"Polygon","coordinates":` + forestCoordinates + `}]}`,
+			points: []point{{"forest", 2, 25, true}, {"point", 5, 5, false}},
+		},
+		{
+			name:    "Feature",
+			geojson: `{"type":"Feature","properties":{"name":"park"},"geometry":{"type":"Polygon","coordinates":` + parkCoordinates + `}}`,
+			points:  []point{{"park", 2, 2, true}, {"pond", 5, 5, false}},
+		},
+		{
+			name: "FeatureCollection",
+			geojson: `{"type":"FeatureCollection","features":[
+				{"type":"Feature","properties":{},"geometry":{"type":"Polygon","coordinates":` + parkCoordinates + `}},
+				{"type":"Feature","properties":{},"geometry":null},
+				{"type":"Feature","properties":{},"geometry":{"type":"LineString","coordinates":[[0,0],[30,10]]}},
+				{"type":"Feature","properties":{},"geometry":{"type":"MultiPolygon","coordinates":[` + forestCoordinates + `]}}]}`,
+			points: []point{{"park", 2, 2, true}, {"pond", 5, 5, false}, {"forest", 2, 25, true}, {"between", 5, 15, false}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fence, err := ParseGeoJSON([]byte(tt.geojson))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkContains(t, fence, tt.points)
+		})
+	}
+}
+
+func TestParseGeoJSONBounds(t *testing.T) {
+	fence, err := ParseGeoJSON([]byte(`{"type":"MultiPolygon","coordinates":[` + parkCoordinates + `,` + forestCoordinates + `]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := fence.Bounds(); b != (BBox{0, 0, 30, 10}) {
+		t.Errorf("bounds = %v, want [0 0 30 10]", b)
+	}
+}
+
+func TestParseGeoJSONErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		geojson string
+	}{
+		{"not json", `{"type":`},
+		{"unknown type", `{"type":"Circle","coordinates":[0,0]}`},
+		{"no polygons", `{"type":"Point","coordinates":[0,0]}`},
+		{"empty feature collection", `{"type":"FeatureCollection","features":[]}`},
+		{"polygon without rings", `{"type":"Polygon","coordinates":[]}`},
+		{"too short ring", `{"type":"Polygon","coordinates":[[[0,0],[10,0],[0,0]]]}`},
+		{"unclosed ring", `{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,10]]]}`},
+		{"unclosed hole", `{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,10],[0,0]],[[4,4],[6,4],[6,6],[4,6]]]}`},
+		{"unclosed ring in a multipolygon", `{"type":"MultiPolygon","coordinates":[` + forestCoordinates + `,[[[0,0],[10,0],[10,10],[0,10]]]]}`},
+		{"invalid coordinates", `{"type":"Polygon","coordinates":[[["0","0"]]]}`},
+		{"invalid feature", `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[0,0]]]}}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseGeoJSON([]byte(tt.geojson)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadGeoJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "park.geojson")
+	if err := ioutil.WriteFile(path, []byte(`{"type":"Polygon","coordinates":`+parkCoordinates+`}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fence, err := LoadGeoJSON(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkContains(t, fence, []point{{"park", 2, 2, true}})
+
+	if _, err := LoadGeoJSON(filepath.Join(t.TempDir(), "missing.geojson")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestFeatureCollection(t *testing.T) {
+	fc := NewFeatureCollection()
+	fc.AddPoint(42.69, 23.32, map[string]int{"visits": 3})
+
+	content, err := json.Marshal(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Point","coordinates":[23.32,42.69]},"properties":{"visits":3}}]}`
+	if string(content) != want {
+		t.Errorf("got %s\nwant %s", content, want)
+	}
+	if content, _ := json.Marshal(NewFeatureCollection()); string(content) != `{"type":"FeatureCollection","features":[]}` {
+		t.Errorf("empty collection is %s", content)
+	}
+}
@@ -5,5 +5,6 @@ go 1.15
 require (
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/minio/minio-go/v7 v7.0.8
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/oauth2 v0.0.0-20210210192628-66670185b0cd
 )
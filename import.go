@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/IcoBoyanov/lazy-spots/config"
+	"github.com/IcoBoyanov/lazy-spots/importer"
+)
+
+// importMain stores GPX, TCX and FIT files as rides of an athlete:
+//
+//	lazy-spots import [flags] <athlete> <file>...
+//
+// The flags are the ones of the server, Strava credentials are not needed.
+func importMain(name string, args []string) {
+	cfg, err := config.Load(name, args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(cfg.Args()) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <athlete> <file>...\n", name)
+		os.Exit(2)
+	}
+	if cfg.Storage.Backend == config.StorageMemory {
+		log.Printf("imported rides are lost on exit with the memory storage backend")
+	}
+
+	repo, err := newRepository(cfg.Storage, log.New(log.Writer(), "storage: ", log.LstdFlags))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	athlete, files := cfg.Args()[0], cfg.Args()[1:]
+	detector := cfg.StopDetector()
+
+	failed := 0
+	for _, file := range files {
+		activity, err := importer.ImportFile(file)
+		if err != nil {
+			log.Printf("%s: %v", file, err)
+			failed++
+			continue
+		}
+		spots, err := activity.Store(repo, athlete, detector)
+		if err != nil {
+			log.Printf("%s: %v", file, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: stored as %s with %d stops\n", file, activity.ID, len(spots.Data))
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d files were not imported", failed, len(files))
+	}
+}
@@ -0,0 +1,190 @@
+package importer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// FIT is Garmin's binary activity format. Only the position and time of
+// record messages are decoded, other messages are skipped by their
+// definitions. https://developer.garmin.com/fit/protocol/
+const (
+	fitRecordMessage = 20
+
+	fitFieldLat       = 0
+	fitFieldLng       = 1
+	fitFieldTimestamp = 253
+
+	fitInvalidSint32 = math.MaxInt32
+	fitInvalidUint32 = math.MaxUint32
+)
+
+// fitEpoch is the time FIT timestamps are counted from
+var fitEpoch = time.Date(1989, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+type fitField struct {
+	num, size byte
+}
+
+type fitDefinition struct {
+	global    uint16
+	order     binary.ByteOrder
+	fields    []fitField
+	devFields []fitField
+}
+
+// fitReader reads the data records of a FIT file
+type fitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *fitReader) next(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, fmt.Errorf("fit file is truncated at byte %d", r.pos)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *fitReader) byte() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// parseFIT reads the record messages of the first FIT file in content,
+// records without a position are skipped
+func parseFIT(content []byte) ([]trackPoint, error) {
+	if len(content) < 12 {
+		return nil, fmt.Errorf("fit header is truncated")
+	}
+	headerSize := int(content[0])
+	if headerSize < 12 || string(content[8:12]) != ".FIT" {
+		return nil, fmt.Errorf("not a fit file")
+	}
+	end := headerSize + int(binary.LittleEndian.Uint32(content[4:8]))
+	if end > len(content) {
+		return nil, fmt.Errorf("fit file is truncated, expected %d bytes of data", end-headerSize)
+	}
+
+	r := &fitReader{data: content[:end], pos: headerSize}
+	definitions := make(map[byte]*fitDefinition)
+	var points []trackPoint
+	var timestamp uint32
+	for r.pos < len(r.data) {
+		header, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		// Compressed timestamp headers carry the low 5 bits of the time
+		// relative to the last full timestamp
+		compressed := header&0x80 != 0
+		local := header & 0x0f
+		if compressed {
+			local = (header >> 5) & 0x03
+			offset := uint32(header & 0x1f)
+			if offset >= timestamp&0x1f {
+				timestamp = timestamp&^0x1f + offset
+			} else {
+				timestamp = timestamp&^0x1f + offset + 0x20
+			}
+		} else if header&0x40 != 0 {
+			def, err := r.definition(header&0x20 != 0)
+			if err != nil {
+				return nil, err
+			}
+			definitions[local] = def
+			continue
+		}
+
+		def, ok := definitions[local]
+		if !ok {
+			return nil, fmt.Errorf("fit data message of undefined local type %d at byte %d", local, r.pos-1)
+		}
+		values := make(map[byte]uint32)
+		for _, f := range def.fields {
+			b, err := r.next(int(f.size))
+			if err != nil {
+				return nil, err
+			}
+			if f.size == 4 {
+				values[f.num] = def.order.Uint32(b)
+			}
+		}
+		for _, f := range def.devFields {
+			if _, err := r.next(int(f.size)); err != nil {
+				return nil, err
+			}
+		}
+
+		ts, hasTime := values[fitFieldTimestamp]
+		hasTime = hasTime && ts != fitInvalidUint32
+		if hasTime {
+			timestamp = ts
+		}
+		if def.global != fitRecordMessage || !(hasTime || compressed) {
+			continue
+		}
+		lat, hasLat := values[fitFieldLat]
+		lng, hasLng := values[fitFieldLng]
+		if !hasLat || !hasLng || int32(lat) == fitInvalidSint32 || int32(lng) == fitInvalidSint32 {
+			continue
+		}
+		points = append(points, trackPoint{
+			lat:  semicircles(lat),
+			lng:  semicircles(lng),
+			time: fitEpoch.Add(time.Duration(timestamp) * time.Second),
+		})
+	}
+	return points, nil
+}
+
+func (r *fitReader) definition(developer bool) (*fitDefinition, error) {
+	b, err := r.next(5)
+	if err != nil {
+		return nil, err
+	}
+	def := &fitDefinition{order: binary.LittleEndian}
+	if b[1] == 1 {
+		def.order = binary.BigEndian
+	}
+	def.global = def.order.Uint16(b[2:4])
+
+	if def.fields, err = r.fields(int(b[4])); err != nil {
+		return nil, err
+	}
+	if developer {
+		n, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		if def.devFields, err = r.fields(int(n)); err != nil {
+			return nil, err
+		}
+	}
+	return def, nil
+}
+
+func (r *fitReader) fields(n int) ([]fitField, error) {
+	b, err := r.next(3 * n)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]fitField, n)
+	for i := range fields {
+		fields[i] = fitField{num: b[3*i], size: b[3*i+1]}
+	}
+	return fields, nil
+}
+
+// semicircles converts a FIT position to degrees
+func semicircles(v uint32) float64 {
+	return float64(int32(v)) * 180 / (1 << 31)
+}
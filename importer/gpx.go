@@ -0,0 +1,42 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"time"
+)
+
+// GPX 1.0 and 1.1 tracks https://www.topografix.com/GPX/1/1/
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []struct {
+				Lat  *float64  `xml:"lat,attr"`
+				Lon  *float64  `xml:"lon,attr"`
+				Time time.Time `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// parseGPX reads the points of all tracks, points without a time or a
+// position are skipped
+func parseGPX(content []byte) ([]trackPoint, error) {
+	var doc gpxFile
+	if err := xml.NewDecoder(bytes.NewReader(content)).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var points []trackPoint
+	for _, track := range doc.Tracks {
+		for _, segment := range track.Segments {
+			for _, p := range segment.Points {
+				if p.Time.IsZero() || p.Lat == nil || p.Lon == nil {
+					continue
+				}
+				points = append(points, trackPoint{lat: *p.Lat, lng: *p.Lon, time: p.Time})
+			}
+		}
+	}
+	return points, nil
+}
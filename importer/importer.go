@@ -0,0 +1,140 @@
+// Package importer reads rides from GPX, TCX and FIT files into activity
+// streams, so rides which are not on Strava can be analysed as well
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/IcoBoyanov/lazy-spots/repository"
+)
+
+type Format string
+
+const (
+	GPX Format = "gpx"
+	TCX Format = "tcx"
+	FIT Format = "fit"
+)
+
+// IDPrefix starts the ids of imported rides, they never clash with Strava's
+// numeric activity ids
+const IDPrefix = "import-"
+
+// Activity is a ride read from a file. The id is derived from the content, so
+// importing a file again replaces the ride.
+type Activity struct {
+	ID     string
+	Start  time.Time
+	Stream *model.ActivityStream
+}
+
+// trackPoint is a position of a track, files are reduced to these
+type trackPoint struct {
+	lat, lng float64
+	time     time.Time
+}
+
+// FormatOf returns the format of a file by its extension
+func FormatOf(name string) (Format, error) {
+	switch f := Format(strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))); f {
+	case GPX, TCX, FIT:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown file type '%s', expected .gpx, .tcx or .fit", filepath.Ext(name))
+	}
+}
+
+// ImportFile reads the file in the format given by its extension
+func ImportFile(path string) (*Activity, error) {
+	format, err := FormatOf(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open '%s': %v", path, err)
+	}
+	defer f.Close()
+	return Import(f, format)
+}
+
+// Import reads a ride in the given format
+func Import(r io.Reader, format Format) (*Activity, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", format, err)
+	}
+
+	var points []trackPoint
+	switch format {
+	case GPX:
+		points, err = parseGPX(content)
+	case TCX:
+		points, err = parseTCX(content)
+	case FIT:
+		points, err = parseFIT(content)
+	default:
+		return nil, fmt.Errorf("unknown format '%s'", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", format, err)
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("%s has no track points with a position and a time", format)
+	}
+
+	sum := sha256.Sum256(content)
+	stream, err := newActivityStream(points)
+	if err != nil {
+		return nil, err
+	}
+	return &Activity{
+		ID:     IDPrefix + hex.EncodeToString(sum[:8]),
+		Start:  points[0].time,
+		Stream: stream,
+	}, nil
+}
+
+// newActivityStream converts the points to latlng, time and moving streams,
+// time is in seconds from the first point. Files do not record whether the
+// rider was moving, it is derived by DefaultMotionClassifier.
+func newActivityStream(points []trackPoint) (*model.ActivityStream, error) {
+	latlng := make(model.LatLngStream, len(points))
+	times := make(model.IntStream, len(points))
+	for i, p := range points {
+		latlng[i] = [2]float64{p.lat, p.lng}
+		times[i] = int(p.time.Sub(points[0].time) / time.Second)
+	}
+
+	var as model.ActivityStream
+	for _, sd := range []model.StreamData{
+		{Type: model.StreamTypeLatLng, Size: len(points), Data: latlng},
+		{Type: model.StreamTypeTime, Size: len(points), Data: times},
+		{Type: model.StreamTypeMoving, Size: len(points), Data: model.DefaultMotionClassifier.Classify(latlng, times)},
+	} {
+		if err := as.Add(sd); err != nil {
+			return nil, err
+		}
+	}
+	return &as, nil
+}
+
+// Store stores the ride and the stops the detector finds in it the same way
+// collected Strava activities are stored
+func (a *Activity) Store(repo repository.Repository, athlete string, detector model.StopDetector) (*model.SpotList, error) {
+	sl := detector.SpotList(a.Stream)
+	sl.SetActivity(a.ID, a.Start)
+	if err := repository.StoreRide(repo, athlete, a.ID, a.Stream, sl); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
@@ -0,0 +1,358 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+)
+
+var (
+	testStart = time.Date(2021, 3, 4, 8, 0, 0, 0, time.UTC)
+	// testLat and testLng are exact in semicircles
+	testLat = degrees(fitSemicircles(42.69))
+	testLng = degrees(fitSemicircles(23.32))
+)
+
+// wantPoint is a point of a fixture, the time in seconds from testStart
+type wantPoint struct {
+	lat, lng float64
+	seconds  int
+}
+
+func checkPoints(t *testing.T, points []trackPoint, want []wantPoint) {
+	t.Helper()
+	if len(points) != len(want) {
+		t.Fatalf("got %d points, want %d: %v", len(points), len(want), points)
+	}
+	for i, w := range want {
+		p := points[i]
+		if math.Abs(p.lat-w.lat) > 1e-7 || math.Abs(p.lng-w.lng) > 1e-7 {
+			t.Errorf("point %d at %f,%f, want %f,%f", i, p.lat, p.lng, w.lat, w.lng)
+		}
+		if wantTime := testStart.Add(time.Duration(w.seconds) * time.Second); !p.time.Equal(wantTime) {
+			t.Errorf("point %d at %v, want %v", i, p.time, wantTime)
+		}
+	}
+}
+
+func fitSemicircles(deg float64) int32 {
+	return int32(math.Round(deg * (1 << 31) / 180))
+}
+
+func degrees(v int32) float64 {
+	return semicircles(uint32(v))
+}
+
+// fitFile builds a FIT file of messages
+type fitFile struct {
+	data bytes.Buffer
+}
+
+// field is a field definition, the base type is not read by the parser
+type field struct {
+	num, size byte
+}
+
+var (
+	latField  = field{fitFieldLat, 4}
+	lngField  = field{fitFieldLng, 4}
+	timeField = field{fitFieldTimestamp, 4}
+	// speedField is a field the parser does not read
+	speedField = field{6, 2}
+)
+
+func (f *fitFile) define(local byte, global uint16, order binary.ByteOrder, fields ...field) *fitFile {
+	arch := byte(0)
+	if order == binary.BigEndian {
+		arch = 1
+	}
+	f.data.Write([]byte{0x40 | local, 0, arch})
+	var num [2]byte
+	order.PutUint16(num[:], global)
+	f.data.Write(num[:])
+	f.data.WriteByte(byte(len(fields)))
+	for _, fd := range fields {
+		f.data.Write([]byte{fd.num, fd.size, 0x86})
+	}
+	return f
+}
+
+// message writes a data message with the given header, values are written
+// in order with the sizes of their fields
+func (f *fitFile) message(header byte, order binary.ByteOrder, values ...interface{}) *fitFile {
+	f.data.WriteByte(header)
+	for _, v := range values {
+		binary.Write(&f.data, order, v)
+	}
+	return f
+}
+
+func (f *fitFile) bytes() []byte {
+	header := make([]byte, 14)
+	header[0] = 14
+	header[1] = 0x10
+	binary.LittleEndian.PutUint16(header[2:4], 2132)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(f.data.Len()))
+	copy(header[8:12], ".FIT")
+	// the header and file CRCs are not checked
+	content := append(header, f.data.Bytes()...)
+	return append(content, 0, 0)
+}
+
+func fitTime(seconds int) uint32 {
+	return uint32(testStart.Add(time.Duration(seconds)*time.Second).Sub(fitEpoch) / time.Second)
+}
+
+func TestParseFIT(t *testing.T) {
+	if fitTime(0)&0x1f != 0 {
+		t.Fatal("the compressed timestamps expect testStart to be a multiple of 32 seconds")
+	}
+	le, be := binary.LittleEndian, binary.BigEndian
+	lat, lng := fitSemicircles(42.69), fitSemicircles(23.32)
+	north := fitSemicircles(42.7)
+
+	tests := []struct {
+		name string
+		file *fitFile
+		want []wantPoint
+	}{
+		{
+			name: "records",
+			file: new(fitFile).
+				// a file_id message is skipped by its definition
+				define(0, 0, le, field{4, 4}, speedField).
+				message(0x00, le, uint32(1), uint16(0)).
+				define(1, fitRecordMessage, le, timeField, latField, lngField, speedField).
+				message(0x01, le, fitTime(0), lat, lng, uint16(500)).
+				message(0x01, le, fitTime(1), north, lng, uint16(500)),
+			want: []wantPoint{{testLat, testLng, 0}, {degrees(north), testLng, 1}},
+		},
+		{
+			name: "big endian",
+			file: new(fitFile).
+				define(0, fitRecordMessage, be, timeField, latField, lngField).
+				message(0x00, be, fitTime(0), lat, lng).
+				message(0x00, be, fitTime(5), north, lng),
+			want: []wantPoint{{testLat, testLng, 0}, {degrees(north), testLng, 5}},
+		},
+		{
+			name: "compressed timestamps",
+			file: new(fitFile).
+				define(0, fitRecordMessage, le, timeField, latField, lngField).
+				define(1, fitRecordMessage, le, latField, lngField).
+				message(0x00, le, fitTime(0), lat, lng).
+				// local type 1 with the low 5 bits of the time, the full
+				// timestamp is a multiple of 32 so 40 wraps after 20
+				message(0x80|1<<5|byte(fitTime(20)&0x1f), le, north, lng).
+				message(0x80|1<<5|byte(fitTime(40)&0x1f), le, lat, lng),
+			want: []wantPoint{{testLat, testLng, 0}, {degrees(north), testLng, 20}, {testLat, testLng, 40}},
+		},
+		{
+			name: "invalid positions",
+			file: new(fitFile).
+				define(0, fitRecordMessage, le, timeField, latField, lngField).
+				message(0x00, le, fitTime(0), int32(fitInvalidSint32), int32(fitInvalidSint32)).
+				message(0x00, le, fitTime(1), lat, int32(fitInvalidSint32)).
+				message(0x00, le, fitTime(2), lat, lng),
+			want: []wantPoint{{testLat, testLng, 2}},
+		},
+		{
+			name: "records without a position or time",
+			file: new(fitFile).
+				define(0, fitRecordMessage, le, timeField, speedField).
+				message(0x00, le, fitTime(0), uint16(0)).
+				define(1, fitRecordMessage, le, latField, lngField).
+				message(0x01, le, lat, lng).
+				define(2, fitRecordMessage, le, timeField, latField, lngField).
+				message(0x02, le, uint32(fitInvalidUint32), lat, lng).
+				message(0x02, le, fitTime(3), lat, lng),
+			want: []wantPoint{{testLat, testLng, 3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := parseFIT(tt.file.bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPoints(t, points, tt.want)
+		})
+	}
+}
+
+func TestParseFITErrors(t *testing.T) {
+	le := binary.LittleEndian
+	valid := new(fitFile).
+		define(0, fitRecordMessage, le, timeField, latField, lngField).
+		message(0x00, le, fitTime(0), fitSemicircles(42.69), fitSemicircles(23.32)).
+		bytes()
+
+	// The header's data size matches the data in these, the last message is
+	// cut short
+	truncatedMessage := new(fitFile).
+		define(0, fitRecordMessage, le, timeField, latField, lngField).
+		message(0x00, le, fitTime(0), fitSemicircles(42.69)).
+		bytes()
+	truncatedDefinition := new(fitFile).
+		define(0, fitRecordMessage, le, timeField, latField, lngField).
+		bytes()
+	truncatedDefinition = append(truncatedDefinition[:len(truncatedDefinition)-5], 0, 0)
+	binary.LittleEndian.PutUint32(truncatedDefinition[4:8], uint32(len(truncatedDefinition)-16))
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"empty", nil},
+		{"truncated header", valid[:10]},
+		{"not a fit file", append([]byte{14, 0x10, 0, 0, 0, 0, 0, 0, '.', 'G', 'P', 'X', 0, 0}, 0, 0)},
+		{"truncated file", valid[:len(valid)-6]},
+		{"truncated message", truncatedMessage},
+		{"truncated definition", truncatedDefinition},
+		{"undefined local type", new(fitFile).message(0x03, le, fitTime(0)).bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if points, err := parseFIT(tt.content); err == nil {
+				t.Fatalf("got %d points, want an error", len(points))
+			}
+		})
+	}
+}
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk>
+    <name>Morning Ride</name>
+    <trkseg>
+      <trkpt lat="42.69" lon="23.32"><ele>550</ele><time>2021-03-04T08:00:00Z</time></trkpt>
+      <trkpt lat="42.691" lon="23.321"><ele>551</ele></trkpt>
+      <trkpt><time>2021-03-04T08:00:02Z</time></trkpt>
+      <trkpt lat="42.692" lon="23.322"><time>2021-03-04T10:00:03+02:00</time></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="0" lon="0"><time>2021-03-04T08:00:10Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+func TestParseGPX(t *testing.T) {
+	points, err := parseGPX([]byte(testGPX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPoints(t, points, []wantPoint{{42.69, 23.32, 0}, {42.692, 23.322, 3}, {0, 0, 10}})
+}
+
+const testTCX = `<?xml version="1.0" encoding="UTF-8"?>
+<TrainingCenterDatabase xmlns="http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2">
+  <Activities>
+    <Activity Sport="Biking">
+      <Id>2021-03-04T08:00:00Z</Id>
+      <Lap StartTime="2021-03-04T08:00:00Z">
+        <Track>
+          <Trackpoint>
+            <Time>2021-03-04T08:00:00Z</Time>
+            <Position><LatitudeDegrees>42.69</LatitudeDegrees><LongitudeDegrees>23.32</LongitudeDegrees></Position>
+          </Trackpoint>
+          <Trackpoint>
+            <Time>2021-03-04T08:00:01Z</Time>
+            <HeartRateBpm><Value>120</Value></HeartRateBpm>
+          </Trackpoint>
+          <Trackpoint>
+            <Position><LatitudeDegrees>42.691</LatitudeDegrees><LongitudeDegrees>23.321</LongitudeDegrees></Position>
+          </Trackpoint>
+        </Track>
+      </Lap>
+      <Lap StartTime="2021-03-04T08:00:05Z">
+        <Track>
+          <Trackpoint>
+            <Time>2021-03-04T08:00:05Z</Time>
+            <Position><LatitudeDegrees>42.692</LatitudeDegrees><LongitudeDegrees>23.322</LongitudeDegrees></Position>
+          </Trackpoint>
+        </Track>
+      </Lap>
+    </Activity>
+  </Activities>
+</TrainingCenterDatabase>`
+
+func TestParseTCX(t *testing.T) {
+	points, err := parseTCX([]byte(testTCX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPoints(t, points, []wantPoint{{42.69, 23.32, 0}, {42.692, 23.322, 5}})
+}
+
+func TestImport(t *testing.T) {
+	tests := []struct {
+		format  Format
+		content []byte
+		samples int
+	}{
+		{GPX, []byte(testGPX), 3},
+		{TCX, []byte(testTCX), 2},
+		{FIT, new(fitFile).
+			define(0, fitRecordMessage, binary.LittleEndian, timeField, latField, lngField).
+			message(0x00, binary.LittleEndian, fitTime(0), fitSemicircles(42.69), fitSemicircles(23.32)).
+			message(0x00, binary.LittleEndian, fitTime(7), fitSemicircles(42.7), fitSemicircles(23.32)).
+			bytes(), 2},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			activity, err := Import(bytes.NewReader(tt.content), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(activity.ID, IDPrefix) {
+				t.Errorf("id %q does not start with %q", activity.ID, IDPrefix)
+			}
+			if !activity.Start.Equal(testStart) {
+				t.Errorf("start = %v, want %v", activity.Start, testStart)
+			}
+			if activity.Stream.Len() != tt.samples {
+				t.Fatalf("got %d samples, want %d", activity.Stream.Len(), tt.samples)
+			}
+			for _, streamType := range []string{model.StreamTypeLatLng, model.StreamTypeTime, model.StreamTypeMoving} {
+				if activity.Stream.Stream(streamType) == nil {
+					t.Errorf("no %s stream", streamType)
+				}
+			}
+			if times := activity.Stream.Time(); times[0] != 0 {
+				t.Errorf("time stream starts at %d, want 0", times[0])
+			}
+
+			again, err := Import(bytes.NewReader(tt.content), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.ID != activity.ID {
+				t.Errorf("importing the file again gives id %q, want %q", again.ID, activity.ID)
+			}
+		})
+	}
+}
+
+func TestImportWithoutPoints(t *testing.T) {
+	gpx := `<gpx version="1.1"><trk><trkseg><trkpt lat="42.69" lon="23.32"/></trkseg></trk></gpx>`
+	if _, err := Import(strings.NewReader(gpx), GPX); err == nil {
+		t.Error("expected an error for a track without times")
+	}
+	if _, err := Import(strings.NewReader(testGPX), Format("kml")); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	for name, want := range map[string]Format{"ride.gpx": GPX, "RIDE.TCX": TCX, "a/b.c.fit": FIT, "ride.kml": "", "ride": ""} {
+		got, err := FormatOf(name)
+		if got != want || (want == "") != (err != nil) {
+			t.Errorf("FormatOf(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
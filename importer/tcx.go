@@ -0,0 +1,49 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/xml"
+	"time"
+)
+
+// Garmin Training Center XML
+// https://www8.garmin.com/xmlschemas/TrainingCenterDatabasev2.xsd
+type tcxFile struct {
+	Activities []struct {
+		Laps []struct {
+			Tracks []struct {
+				Points []struct {
+					Time     time.Time `xml:"Time"`
+					Position *struct {
+						Lat float64 `xml:"LatitudeDegrees"`
+						Lng float64 `xml:"LongitudeDegrees"`
+					} `xml:"Position"`
+				} `xml:"Trackpoint"`
+			} `xml:"Track"`
+		} `xml:"Lap"`
+	} `xml:"Activities>Activity"`
+}
+
+// parseTCX reads the points of all activities, points without a position,
+// e.g. while the GPS had no fix, are skipped
+func parseTCX(content []byte) ([]trackPoint, error) {
+	var doc tcxFile
+	if err := xml.NewDecoder(bytes.NewReader(content)).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var points []trackPoint
+	for _, activity := range doc.Activities {
+		for _, lap := range activity.Laps {
+			for _, track := range lap.Tracks {
+				for _, p := range track.Points {
+					if p.Position == nil || p.Time.IsZero() {
+						continue
+					}
+					points = append(points, trackPoint{lat: p.Position.Lat, lng: p.Position.Lng, time: p.Time})
+				}
+			}
+		}
+	}
+	return points, nil
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 
+	"github.com/IcoBoyanov/lazy-spots/config"
 	"github.com/IcoBoyanov/lazy-spots/repository"
+	"github.com/IcoBoyanov/lazy-spots/repository/boltdb"
+	"github.com/IcoBoyanov/lazy-spots/repository/filesystem"
+	"github.com/IcoBoyanov/lazy-spots/repository/memory"
 	"github.com/IcoBoyanov/lazy-spots/repository/miniocli"
 	"github.com/IcoBoyanov/lazy-spots/server"
 	"github.com/IcoBoyanov/lazy-spots/strava"
@@ -16,67 +22,133 @@ import (
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
-const (
-	MinioAccessKeyEnv = "MINIO_ACCESS_KEY"
-	MinioSecretEnv    = "MINIO_SECRET"
-
-	// Local minio instance endpoint
-	Endpoint = "172.17.0.2:9000"
-	UseSSL   = false
-
-	ServerURL = "http://localhost"
-)
-
 var (
-	minioAccessKey string
-	minioSecret    string
-	servePort      string
-	repo           repository.Repository
-	requestServer  *server.RequestServer
-	logger         *log.Logger
+	repo          repository.Repository
+	requestServer *server.RequestServer
+	logger        *log.Logger
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		importMain(os.Args[0]+" import", os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrateMain(os.Args[0]+" migrate", os.Args[2:])
+		return
+	}
 
-	minioAccessKey = os.Getenv(MinioAccessKeyEnv)
-	minioSecret = os.Getenv(MinioSecretEnv)
-
-	flag.StringVar(&servePort, "port", ":8888", "serve port")
-	flag.Parse()
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := cfg.RequireStrava(); err != nil {
+		log.Fatalln(err)
+	}
 
-	// Initialize minio client object.
-	minioClient, err := minio.New(Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(minioAccessKey, minioSecret, ""),
-		Secure: UseSSL,
-	})
+	repo, err = newRepository(cfg.Storage, log.New(log.Writer(), "storage: ", log.LstdFlags))
 	if err != nil {
 		log.Fatalln(err)
 	}
-	repo = miniocli.New(log.New(log.Writer(), "storage: ", log.LstdFlags), minioClient)
-	client, err := strava.NewStravaService(ServerURL + servePort + "/callback")
+	client, err := strava.NewStravaService(cfg.CallbackURL(), cfg.Strava.ClientID, cfg.Strava.ClientSecret, repo)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "could not create strava client: %v", err)
+		log.Fatalf("could not create strava client: %v", err)
+	}
+	requestServer = server.NewRequestServer(repo, client, sessionKey(cfg.SessionSecret))
+	requestServer.SetCollectWorkers(cfg.Collect.Workers)
+	requestServer.SetRegionFilter(cfg.Regions())
+	requestServer.SetStopDetector(cfg.StopDetector())
+	requestServer.SetSpotsDefaults(cfg.Spots.ClusterRadius, cfg.Spots.Limit)
+	if cfg.Strava.WebhookVerifyToken != "" {
+		if cfg.Strava.WebhookSubscription == 0 {
+			log.Printf("webhook subscription is not set, webhook events are refused until it is")
+		}
+		requestServer.SetWebhook(cfg.Strava.WebhookVerifyToken, cfg.Strava.WebhookSubscription)
 	}
-	requestServer = server.NewRequestServer(repo, client)
 
 	router := httprouter.New()
 	router.GET("/", Home)
 	router.GET("/login", requestServer.Login)
 	router.GET("/callback", requestServer.Callback)
+	router.GET("/logout", requestServer.Logout)
 	router.GET("/athlete", requestServer.GetAthleteData)
-	router.GET("/collect", requestServer.CollectAthleteActivities)
+	router.DELETE("/athlete", requestServer.DeleteAthlete)
+	router.POST("/rides", requestServer.ImportRides)
+	router.DELETE("/rides/:id", requestServer.DeleteRide)
+	router.POST("/jobs/collect", requestServer.StartCollection)
+	router.GET("/collect/events", requestServer.CollectionEvents)
+	router.GET("/jobs/:id", requestServer.GetJob)
+	router.DELETE("/jobs/:id", requestServer.CancelJob)
 	router.GET("/places", requestServer.GetMapPlaces)
+	router.GET("/places.geojson", requestServer.GetMapPlaces)
+	router.GET("/places.gpx", requestServer.GetMapPlaces)
+	router.GET("/places.kml", requestServer.GetMapPlaces)
+	router.GET("/spots", requestServer.GetSpots)
+	router.GET("/spots.geojson", requestServer.GetSpots)
+	router.GET("/spots.gpx", requestServer.GetSpots)
+	router.GET("/spots.kml", requestServer.GetSpots)
+	router.GET("/quota", requestServer.GetQuota)
+	router.GET("/webhook", requestServer.ValidateWebhook)
+	router.POST("/webhook", requestServer.ReceiveWebhook)
+	router.GET("/region", requestServer.GetRegion)
 	router.ServeFiles("/static/*filepath", http.Dir("./web"))
 
-	if err := http.ListenAndServe(servePort, router); err != nil {
+	log.Printf("listening on %s, reachable at %s", cfg.Listen, cfg.PublicURL)
+	if err := http.ListenAndServe(cfg.Listen, router); err != nil {
 		fmt.Fprintf(os.Stderr, "server is down: %v", err)
 		os.Exit(1)
 	}
 
 }
 
+func newRepository(cfg config.StorageConfig, logger *log.Logger) (repository.Repository, error) {
+	switch cfg.Backend {
+	case config.StorageMinio:
+		// Initialize minio client object.
+		minioClient, err := minio.New(cfg.Minio.Endpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(cfg.Minio.AccessKey, cfg.Minio.Secret, ""),
+			Secure: cfg.Minio.UseSSL,
+		})
+		if err != nil {
+			return nil, err
+		}
+		repo, err := miniocli.New(context.Background(), logger, minioClient, cfg.Minio.Buckets)
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	case config.StorageFS:
+		return filesystem.New(logger, cfg.Dir)
+	case config.StorageBolt:
+		repo, err := boltdb.New(logger, cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	default:
+		return memory.New(logger), nil
+	}
+}
+
+// sessionKey signs the session cookies. Without a configured secret sessions
+// do not survive a restart.
+func sessionKey(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	log.Printf("'%s' is not set, using a random session key", config.SessionSecretEnv)
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalln(err)
+	}
+	return key
+}
+
 func Home(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if !requestServer.Authenticated() {
+	if !requestServer.Authenticated(req) {
 		body := `<html><body><a href="/login">Login with Strava</a></body></html>`
 		fmt.Fprintf(w, "%s", body)
 		w.WriteHeader(http.StatusUnauthorized)
@@ -88,9 +160,23 @@ func Home(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	<html><body>
 		<a href="/athlete">get Athlete data</a>
 		</br>
-		<a href="/collect">collect</a>
+		<form method="post" action="/jobs/collect"><button>collect</button></form>
+		<form method="post" action="/jobs/collect?full=true"><button>full resync</button></form>
+		<form method="post" action="/rides" enctype="multipart/form-data">
+			<input type="file" name="file" accept=".gpx,.tcx,.fit" multiple>
+			<button>import</button>
+		</form>
 		</br>
 		<a href="/places">places</a>	
+		</br>
+		<a href="/spots">top spots</a>
+		<a href="/spots.gpx">gpx</a>
+		<a href="/spots.kml">kml</a>
+		</br>
+		<a href="/quota">strava api quota</a>
+		</br>
+		<a href="/logout">logout</a>
+		</br>
 		<a href="/map">go to map</a>	
 	</body></html>
 	`
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/IcoBoyanov/lazy-spots/config"
+)
+
+// migrateMain moves the rides which minio stored without an athlete, before
+// several athletes could use one instance, to the given athlete:
+//
+//	lazy-spots migrate [flags] <athlete>
+//
+// The flags are the ones of the server, Strava credentials are not needed.
+func migrateMain(name string, args []string) {
+	cfg, err := config.Load(name, args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(cfg.Args()) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <athlete>\n", name)
+		os.Exit(2)
+	}
+	if cfg.Storage.Backend != config.StorageMinio {
+		log.Fatalf("only the %s storage backend stored rides without an athlete", config.StorageMinio)
+	}
+
+	repo, err := newRepository(cfg.Storage, log.New(log.Writer(), "storage: ", log.LstdFlags))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	migrator, ok := repo.(interface {
+		MigrateRides(athlete string) (int, error)
+	})
+	if !ok {
+		log.Fatalf("the %s storage backend can not migrate rides", cfg.Storage.Backend)
+	}
+	moved, err := migrator.MigrateRides(cfg.Args()[0])
+	if err != nil {
+		log.Fatalf("moved %d objects: %v", moved, err)
+	}
+	fmt.Printf("moved %d objects to athlete %s\n", moved, cfg.Args()[0])
+}
@@ -0,0 +1,212 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/geo"
+)
+
+// DefaultClusterRadius is the distance in meters within which stops are merged
+const DefaultClusterRadius = 50.0
+
+// DefaultSpotsLimit is the number of clusters returned by /spots
+const DefaultSpotsLimit = 10
+
+// Cluster is a "lazy spot": stops from one or more activities merged together
+type Cluster struct {
+	Lat        float64    `json:"lat"`
+	Lng        float64    `json:"lng"`
+	Visits     int        `json:"visits"`
+	Dwell      int        `json:"dwell"`
+	FirstVisit *time.Time `json:"first_visit,omitempty"`
+	LastVisit  *time.Time `json:"last_visit,omitempty"`
+	Activities []string   `json:"activities"`
+}
+
+type ClusterList struct {
+	Data []Cluster `json:"data"`
+}
+
+// Clusterer merges stops with DBSCAN. Stops closer than Radius meters are
+// neighbours, a stop with at least MinPoints neighbours (itself included)
+// starts a cluster and stops not reachable from any cluster are dropped.
+type Clusterer struct {
+	Radius    float64
+	MinPoints int
+}
+
+// NewClusterList clusters the stops using DefaultClusterRadius
+func NewClusterList(spots *SpotList) *ClusterList {
+	return Clusterer{Radius: DefaultClusterRadius, MinPoints: 1}.ClusterList(spots)
+}
+
+// ClusterList returns the clusters ranked by visits and then by dwell time
+func (c Clusterer) ClusterList(spots *SpotList) *ClusterList {
+	const (
+		unvisited = 0
+		noise     = -1
+	)
+
+	// labels[i] is the 1-based cluster of spots.Data[i]. Stops are labelled
+	// when they are queued, so each one is queued at most once.
+	labels := make([]int, len(spots.Data))
+	index := newGrid(spots.Data, c.Radius)
+	var clusters int
+	for i := range spots.Data {
+		if labels[i] != unvisited {
+			continue
+		}
+		if !c.core(index, spots.Data, i) {
+			labels[i] = noise
+			continue
+		}
+
+		clusters++
+		labels[i] = clusters
+		for queue := []int{i}; len(queue) > 0; queue = queue[1:] {
+			j := queue[0]
+			if j != i && !c.core(index, spots.Data, j) {
+				continue
+			}
+			index.near(spots.Data[j], func(k int) bool {
+				if labels[k] > 0 || !c.neighbours(spots.Data[j], spots.Data[k]) {
+					return true
+				}
+				if labels[k] == unvisited {
+					queue = append(queue, k)
+				}
+				labels[k] = clusters
+				return true
+			})
+		}
+	}
+
+	members := make([][]Spot, clusters)
+	for i, label := range labels {
+		if label > 0 {
+			members[label-1] = append(members[label-1], spots.Data[i])
+		}
+	}
+
+	result := ClusterList{Data: make([]Cluster, 0, clusters)}
+	for _, m := range members {
+		result.Data = append(result.Data, newCluster(m))
+	}
+	sort.SliceStable(result.Data, func(i, j int) bool {
+		if result.Data[i].Visits != result.Data[j].Visits {
+			return result.Data[i].Visits > result.Data[j].Visits
+		}
+		return result.Data[i].Dwell > result.Data[j].Dwell
+	})
+	return &result
+}
+
+func (c Clusterer) neighbours(a, b Spot) bool {
+	return geo.Distance(a.Lat, a.Lng, b.Lat, b.Lng) <= c.Radius
+}
+
+// core reports whether the stop has at least MinPoints neighbours, counting
+// stops only until there are enough
+func (c Clusterer) core(index *grid, spots []Spot, i int) bool {
+	count := 0
+	index.near(spots[i], func(k int) bool {
+		if c.neighbours(spots[i], spots[k]) {
+			count++
+		}
+		return count < c.MinPoints
+	})
+	return count >= c.MinPoints
+}
+
+// grid buckets stops into cells at least the radius wide and high, so the
+// neighbours of a stop are in its own cell and the eight around it
+type grid struct {
+	latCell, lngCell float64
+	cells            map[[2]int][]int
+}
+
+func newGrid(spots []Spot, radius float64) *grid {
+	// A degree of longitude is shortest at the highest latitude
+	maxLat := 0.0
+	for _, s := range spots {
+		maxLat = math.Max(maxLat, math.Abs(s.Lat))
+	}
+	maxLat = math.Min(maxLat, 89)
+
+	latCell := math.Max(radius/geo.MetersPerDegree, 1e-9)
+	g := &grid{
+		latCell: latCell,
+		lngCell: latCell / math.Cos(maxLat*math.Pi/180),
+		cells:   make(map[[2]int][]int),
+	}
+	for i, s := range spots {
+		cell := g.cell(s)
+		g.cells[cell] = append(g.cells[cell], i)
+	}
+	return g
+}
+
+func (g *grid) cell(s Spot) [2]int {
+	return [2]int{int(math.Floor(s.Lat / g.latCell)), int(math.Floor(s.Lng / g.lngCell))}
+}
+
+// near calls fn with the stops of the cells around s until fn returns false
+func (g *grid) near(s Spot, fn func(i int) bool) {
+	cell := g.cell(s)
+	for dlat := -1; dlat <= 1; dlat++ {
+		for dlng := -1; dlng <= 1; dlng++ {
+			for _, i := range g.cells[[2]int{cell[0] + dlat, cell[1] + dlng}] {
+				if !fn(i) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func newCluster(spots []Spot) Cluster {
+	var c Cluster
+	seen := make(map[string]bool)
+	for _, s := range spots {
+		c.Lat += s.Lat
+		c.Lng += s.Lng
+		c.Dwell += s.Duration
+		if s.Time != nil {
+			if c.FirstVisit == nil || s.Time.Before(*c.FirstVisit) {
+				c.FirstVisit = s.Time
+			}
+			if c.LastVisit == nil || s.Time.After(*c.LastVisit) {
+				c.LastVisit = s.Time
+			}
+		}
+		if s.Activity != "" && !seen[s.Activity] {
+			seen[s.Activity] = true
+			c.Activities = append(c.Activities, s.Activity)
+		}
+	}
+	c.Visits = len(spots)
+	c.Lat /= float64(len(spots))
+	c.Lng /= float64(len(spots))
+	return c
+}
+
+// Top keeps only the n highest ranked clusters
+func (cl *ClusterList) Top(n int) {
+	if n >= 0 && n < len(cl.Data) {
+		cl.Data = cl.Data[:n]
+	}
+}
+
+func (cl *ClusterList) Write(out io.Writer) error {
+	return json.NewEncoder(out).Encode(cl)
+}
+
+func (cl *ClusterList) Reader() io.Reader {
+	content, _ := json.Marshal(cl)
+	return bytes.NewReader(content)
+}
@@ -0,0 +1,234 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/geo"
+)
+
+// offset returns a stop dx and dy meters east and north of lat, lng
+func offset(lat, lng, dx, dy float64) Spot {
+	return Spot{Lat: lat + dy/geo.MetersPerDegree, Lng: lng + dx/(geo.MetersPerDegree*0.743), Duration: 60}
+}
+
+// numbered names every stop's activity after its index, so clusters can be
+// compared by their members
+func numbered(spots []Spot) *SpotList {
+	for i := range spots {
+		spots[i].Activity = strconv.Itoa(i)
+	}
+	return &SpotList{Data: spots}
+}
+
+// partition lists the members of every cluster in a comparable form
+func partition(cl *ClusterList) []string {
+	var result []string
+	for _, c := range cl.Data {
+		members := append([]string(nil), c.Activities...)
+		sort.Strings(members)
+		result = append(result, strings.Join(members, ","))
+	}
+	sort.Strings(result)
+	return result
+}
+
+// components clusters with MinPoints 1 by brute force, every stop is in the
+// cluster of all stops within radius of it
+func components(spots []Spot, radius float64) []string {
+	parent := make([]int, len(spots))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range spots {
+		for j := i + 1; j < len(spots); j++ {
+			if geo.Distance(spots[i].Lat, spots[i].Lng, spots[j].Lat, spots[j].Lng) <= radius {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+	groups := make(map[int][]string)
+	for i := range spots {
+		groups[find(i)] = append(groups[find(i)], spots[i].Activity)
+	}
+	var result []string
+	for _, members := range groups {
+		sort.Strings(members)
+		result = append(result, strings.Join(members, ","))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestClusterListMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, tt := range []struct {
+		lat, lng, spread, radius float64
+	}{
+		{42.69, 23.32, 2000, 50},
+		{42.69, 23.32, 500, 120},
+		{-33.86, 151.2, 3000, 50},
+		{69.65, 18.95, 1000, 80},
+		{0, 179.99, 300, 50},
+	} {
+		name := fmt.Sprintf("%g,%g/%gm", tt.lat, tt.lng, tt.radius)
+		t.Run(name, func(t *testing.T) {
+			spots := make([]Spot, 400)
+			for i := range spots {
+				spots[i] = offset(tt.lat, tt.lng, r.Float64()*tt.spread, r.Float64()*tt.spread)
+			}
+			sl := numbered(spots)
+			got := partition(Clusterer{Radius: tt.radius, MinPoints: 1}.ClusterList(sl))
+			want := components(sl.Data, tt.radius)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("got %d clusters, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestClusterList(t *testing.T) {
+	const lat, lng = 42.69, 23.32
+	tests := []struct {
+		name      string
+		spots     []Spot
+		minPoints int
+		want      []string
+	}{
+		{
+			name:      "no stops",
+			minPoints: 1,
+			want:      nil,
+		},
+		{
+			name:      "two places",
+			spots:     []Spot{offset(lat, lng, 0, 0), offset(lat, lng, 1000, 0), offset(lat, lng, 10, 10), offset(lat, lng, 1020, 0)},
+			minPoints: 1,
+			want:      []string{"0,2", "1,3"},
+		},
+		{
+			name:      "chained stops are one cluster",
+			spots:     []Spot{offset(lat, lng, 0, 0), offset(lat, lng, 40, 0), offset(lat, lng, 80, 0), offset(lat, lng, 120, 0)},
+			minPoints: 1,
+			want:      []string{"0,1,2,3"},
+		},
+		{
+			name:      "stops without enough neighbours are dropped",
+			spots:     []Spot{offset(lat, lng, 0, 0), offset(lat, lng, 10, 0), offset(lat, lng, 0, 10), offset(lat, lng, 1000, 0)},
+			minPoints: 3,
+			want:      []string{"0,1,2"},
+		},
+		{
+			name: "border stops join but do not extend a cluster",
+			// 4 is only a neighbour of 1 and 5, it joins the cluster of 1 but
+			// 5 is not reached through it
+			spots: []Spot{
+				offset(lat, lng, 0, 0), offset(lat, lng, 10, 0), offset(lat, lng, 0, 10), offset(lat, lng, 10, 10),
+				offset(lat, lng, 58, -10), offset(lat, lng, 100, -10),
+			},
+			minPoints: 4,
+			want:      []string{"0,1,2,3,4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := partition(Clusterer{Radius: 50, MinPoints: tt.minPoints}.ClusterList(numbered(tt.spots)))
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("clusters = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClusterListRanking(t *testing.T) {
+	const lat, lng = 42.69, 23.32
+	spots := []Spot{
+		offset(lat, lng, 0, 0), offset(lat, lng, 5, 0),
+		offset(lat, lng, 1000, 0), offset(lat, lng, 1005, 0), offset(lat, lng, 1000, 5),
+		offset(lat, lng, 2000, 0),
+	}
+	spots[5].Duration = 600
+	cl := NewClusterList(numbered(spots))
+	var visits []int
+	for _, c := range cl.Data {
+		visits = append(visits, c.Visits)
+	}
+	if fmt.Sprint(visits) != "[3 2 1]" {
+		t.Fatalf("visits = %v, want [3 2 1]", visits)
+	}
+	cl.Top(2)
+	if len(cl.Data) != 2 {
+		t.Fatalf("top 2 kept %d clusters", len(cl.Data))
+	}
+}
+
+func TestClusterVisitTimes(t *testing.T) {
+	const lat, lng = 42.69, 23.32
+	first := time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)
+	last := first.Add(48 * time.Hour)
+	spots := []Spot{offset(lat, lng, 0, 0), offset(lat, lng, 5, 0), offset(lat, lng, 0, 5)}
+	spots[0].Time = &last
+	spots[2].Time = &first
+
+	cl := NewClusterList(&SpotList{Data: spots})
+	if len(cl.Data) != 1 {
+		t.Fatalf("got %d clusters, want 1", len(cl.Data))
+	}
+	c := cl.Data[0]
+	if c.FirstVisit == nil || !c.FirstVisit.Equal(first) || c.LastVisit == nil || !c.LastVisit.Equal(last) {
+		t.Errorf("visits from %v to %v, want %v to %v", c.FirstVisit, c.LastVisit, first, last)
+	}
+
+	// Without any times the visits are left out of the JSON
+	content, err := json.Marshal(NewClusterList(&SpotList{Data: []Spot{offset(lat, lng, 0, 0)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "visit\"") || strings.Contains(string(content), "0001-01-01") {
+		t.Errorf("cluster without times is %s", content)
+	}
+}
+
+// Every stop at one café is a neighbour of every other one
+func BenchmarkClusterListOnePlace(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	spots := make([]Spot, 4000)
+	for i := range spots {
+		spots[i] = offset(42.69, 23.32, r.Float64()*20, r.Float64()*20)
+	}
+	sl := &SpotList{Data: spots}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if cl := NewClusterList(sl); len(cl.Data) != 1 {
+			b.Fatalf("got %d clusters, want 1", len(cl.Data))
+		}
+	}
+}
+
+func BenchmarkClusterListCity(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	spots := make([]Spot, 4000)
+	for i := range spots {
+		spots[i] = offset(42.69, 23.32, r.Float64()*10000, r.Float64()*10000)
+	}
+	sl := &SpotList{Data: spots}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewClusterList(sl)
+	}
+}
@@ -0,0 +1,171 @@
+package model
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// waypoint is a spot or cluster as a named point for GPX and KML
+type waypoint struct {
+	lat, lng   float64
+	name, desc string
+	time       *time.Time
+}
+
+func (s *SpotList) waypoints() []waypoint {
+	points := make([]waypoint, 0, len(s.Data))
+	for i, spot := range s.Data {
+		desc := fmt.Sprintf("%s stop", formatDwell(spot.Duration))
+		if spot.Activity != "" {
+			desc += fmt.Sprintf(" in activity %s", spot.Activity)
+		}
+		points = append(points, waypoint{
+			lat:  spot.Lat,
+			lng:  spot.Lng,
+			name: fmt.Sprintf("Stop %d", i+1),
+			desc: desc,
+			time: spot.Time,
+		})
+	}
+	return points
+}
+
+func (cl *ClusterList) waypoints() []waypoint {
+	points := make([]waypoint, 0, len(cl.Data))
+	for i, c := range cl.Data {
+		visits := "visit"
+		if c.Visits != 1 {
+			visits = "visits"
+		}
+		desc := fmt.Sprintf("%s in total over %d %s", formatDwell(c.Dwell), c.Visits, visits)
+		if c.LastVisit != nil {
+			desc += fmt.Sprintf(", last on %s", c.LastVisit.Format("2006-01-02"))
+		}
+		points = append(points, waypoint{
+			lat:  c.Lat,
+			lng:  c.Lng,
+			name: fmt.Sprintf("#%d lazy spot (%d %s)", i+1, c.Visits, visits),
+			desc: desc,
+			time: c.LastVisit,
+		})
+	}
+	return points
+}
+
+// formatDwell writes seconds as "1 h 5 min", "25 min" or "40 s"
+func formatDwell(seconds int) string {
+	switch {
+	case seconds < 60:
+		return fmt.Sprintf("%d s", seconds)
+	case seconds < 3600:
+		return fmt.Sprintf("%d min", seconds/60)
+	default:
+		return fmt.Sprintf("%d h %d min", seconds/3600, seconds%3600/60)
+	}
+}
+
+// GPX 1.1 https://www.topografix.com/GPX/1/1/
+type gpx struct {
+	XMLName   xml.Name `xml:"gpx"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	Version   string   `xml:"version,attr"`
+	Creator   string   `xml:"creator,attr"`
+	Waypoints []gpxWpt `xml:"wpt"`
+}
+
+type gpxWpt struct {
+	Lat  float64    `xml:"lat,attr"`
+	Lon  float64    `xml:"lon,attr"`
+	Time *time.Time `xml:"time,omitempty"`
+	Name string     `xml:"name"`
+	Desc string     `xml:"desc"`
+}
+
+func writeGPX(out io.Writer, points []waypoint) error {
+	doc := gpx{
+		Xmlns:     "http://www.topografix.com/GPX/1/1",
+		Version:   "1.1",
+		Creator:   "lazy-spots",
+		Waypoints: make([]gpxWpt, 0, len(points)),
+	}
+	for _, p := range points {
+		doc.Waypoints = append(doc.Waypoints, gpxWpt{
+			Lat:  p.lat,
+			Lon:  p.lng,
+			Time: p.time,
+			Name: p.name,
+			Desc: p.desc,
+		})
+	}
+	return writeXML(out, doc)
+}
+
+// KML 2.2 https://developers.google.com/kml/documentation/kmlreference
+type kml struct {
+	XMLName    xml.Name    `xml:"kml"`
+	Xmlns      string      `xml:"xmlns,attr"`
+	Name       string      `xml:"Document>name"`
+	Placemarks []placemark `xml:"Document>Placemark"`
+}
+
+type placemark struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description"`
+	When        string `xml:"TimeStamp>when,omitempty"`
+	Coordinates string `xml:"Point>coordinates"`
+}
+
+func writeKML(out io.Writer, name string, points []waypoint) error {
+	doc := kml{
+		Xmlns:      "http://www.opengis.net/kml/2.2",
+		Name:       name,
+		Placemarks: make([]placemark, 0, len(points)),
+	}
+	for _, p := range points {
+		pm := placemark{
+			Name:        p.name,
+			Description: p.desc,
+			Coordinates: fmt.Sprintf("%g,%g", p.lng, p.lat),
+		}
+		if p.time != nil {
+			pm.When = p.time.UTC().Format(time.RFC3339)
+		}
+		doc.Placemarks = append(doc.Placemarks, pm)
+	}
+	return writeXML(out, doc)
+}
+
+func writeXML(out io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(out)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("could not write xml: %v", err)
+	}
+	_, err := io.WriteString(out, "\n")
+	return err
+}
+
+// WriteGPX writes the stops as GPX waypoints
+func (s *SpotList) WriteGPX(out io.Writer) error {
+	return writeGPX(out, s.waypoints())
+}
+
+// WriteKML writes the stops as KML placemarks
+func (s *SpotList) WriteKML(out io.Writer) error {
+	return writeKML(out, "Stops", s.waypoints())
+}
+
+// WriteGPX writes the clusters as GPX waypoints in ranked order
+func (cl *ClusterList) WriteGPX(out io.Writer) error {
+	return writeGPX(out, cl.waypoints())
+}
+
+// WriteKML writes the clusters as KML placemarks in ranked order
+func (cl *ClusterList) WriteKML(out io.Writer) error {
+	return writeKML(out, "Lazy spots", cl.waypoints())
+}
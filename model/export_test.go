@@ -0,0 +1,137 @@
+package model
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+// gpxDocument and kmlDocument are the GPX and KML output as a reader decodes
+// them
+type gpxDocument struct {
+	XMLName   xml.Name
+	Version   string `xml:"version,attr"`
+	Waypoints []struct {
+		Lat  float64 `xml:"lat,attr"`
+		Lon  float64 `xml:"lon,attr"`
+		Time string  `xml:"time"`
+		Name string  `xml:"name"`
+		Desc string  `xml:"desc"`
+	} `xml:"wpt"`
+}
+
+type kmlDocument struct {
+	XMLName    xml.Name
+	Name       string `xml:"Document>name"`
+	Placemarks []struct {
+		Name        string `xml:"name"`
+		Description string `xml:"description"`
+		When        string `xml:"TimeStamp>when"`
+		Coordinates string `xml:"Point>coordinates"`
+	} `xml:"Document>Placemark"`
+}
+
+func decodeXML(t *testing.T, write func(*bytes.Buffer) error, doc interface{}) {
+	t.Helper()
+	var out bytes.Buffer
+	if err := write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal(out.Bytes(), doc); err != nil {
+		t.Fatalf("invalid xml %s: %v", out.String(), err)
+	}
+}
+
+func exportSpots() *SpotList {
+	at := time.Date(2021, 3, 4, 8, 10, 0, 0, time.UTC)
+	return &SpotList{Data: []Spot{
+		{Lat: 42.69, Lng: 23.32, Start: 600, Duration: 3900, Activity: "4711", Time: &at},
+		// names and descriptions are escaped
+		{Lat: -33.86, Lng: 151.2, Start: 60, Duration: 45, Activity: "<a & b>"},
+	}}
+}
+
+func TestSpotListGPX(t *testing.T) {
+	var doc gpxDocument
+	decodeXML(t, func(out *bytes.Buffer) error { return exportSpots().WriteGPX(out) }, &doc)
+
+	if doc.XMLName.Space != "http://www.topografix.com/GPX/1/1" || doc.XMLName.Local != "gpx" || doc.Version != "1.1" {
+		t.Errorf("root is %v version %q, want GPX 1.1", doc.XMLName, doc.Version)
+	}
+	if len(doc.Waypoints) != 2 {
+		t.Fatalf("got %d waypoints, want 2", len(doc.Waypoints))
+	}
+	first, second := doc.Waypoints[0], doc.Waypoints[1]
+	if first.Lat != 42.69 || first.Lon != 23.32 || first.Time != "2021-03-04T08:10:00Z" {
+		t.Errorf("first waypoint at %g, %g, %q", first.Lat, first.Lon, first.Time)
+	}
+	if first.Name != "Stop 1" || first.Desc != "1 h 5 min stop in activity 4711" {
+		t.Errorf("first waypoint is %q: %q", first.Name, first.Desc)
+	}
+	if second.Lat != -33.86 || second.Lon != 151.2 || second.Time != "" {
+		t.Errorf("second waypoint at %g, %g, %q, want no time", second.Lat, second.Lon, second.Time)
+	}
+	if second.Desc != "45 s stop in activity <a & b>" {
+		t.Errorf("second waypoint is described as %q", second.Desc)
+	}
+}
+
+func TestSpotListKML(t *testing.T) {
+	var doc kmlDocument
+	decodeXML(t, func(out *bytes.Buffer) error { return exportSpots().WriteKML(out) }, &doc)
+
+	if doc.XMLName.Space != "http://www.opengis.net/kml/2.2" || doc.XMLName.Local != "kml" || doc.Name != "Stops" {
+		t.Errorf("root is %v named %q, want a KML 2.2 document", doc.XMLName, doc.Name)
+	}
+	if len(doc.Placemarks) != 2 {
+		t.Fatalf("got %d placemarks, want 2", len(doc.Placemarks))
+	}
+	first, second := doc.Placemarks[0], doc.Placemarks[1]
+	if first.Coordinates != "23.32,42.69" || first.When != "2021-03-04T08:10:00Z" {
+		t.Errorf("first placemark at %q on %q, want lng,lat", first.Coordinates, first.When)
+	}
+	if second.Coordinates != "151.2,-33.86" || second.When != "" {
+		t.Errorf("second placemark at %q on %q, want no time", second.Coordinates, second.When)
+	}
+	if second.Description != "45 s stop in activity <a & b>" {
+		t.Errorf("second placemark is described as %q", second.Description)
+	}
+}
+
+func TestClusterListExport(t *testing.T) {
+	last := time.Date(2021, 3, 4, 8, 10, 0, 0, time.UTC)
+	cl := &ClusterList{Data: []Cluster{
+		{Lat: 42.69, Lng: 23.32, Visits: 3, Dwell: 900, LastVisit: &last},
+		{Lat: 42.7, Lng: 23.4, Visits: 1, Dwell: 120},
+	}}
+
+	var g gpxDocument
+	decodeXML(t, func(out *bytes.Buffer) error { return cl.WriteGPX(out) }, &g)
+	if len(g.Waypoints) != 2 {
+		t.Fatalf("got %d waypoints, want 2", len(g.Waypoints))
+	}
+	if w := g.Waypoints[0]; w.Name != "#1 lazy spot (3 visits)" || w.Desc != "15 min in total over 3 visits, last on 2021-03-04" || w.Time != "2021-03-04T08:10:00Z" {
+		t.Errorf("first waypoint is %q: %q on %q", w.Name, w.Desc, w.Time)
+	}
+	if w := g.Waypoints[1]; w.Name != "#2 lazy spot (1 visit)" || w.Time != "" {
+		t.Errorf("second waypoint is %q on %q", w.Name, w.Time)
+	}
+
+	var k kmlDocument
+	decodeXML(t, func(out *bytes.Buffer) error { return cl.WriteKML(out) }, &k)
+	if k.Name != "Lazy spots" || len(k.Placemarks) != 2 {
+		t.Fatalf("document %q has %d placemarks, want 2", k.Name, len(k.Placemarks))
+	}
+	if p := k.Placemarks[1]; p.Coordinates != "23.4,42.7" || p.When != "" {
+		t.Errorf("second placemark at %q on %q", p.Coordinates, p.When)
+	}
+}
+
+func TestFormatDwell(t *testing.T) {
+	for seconds, want := range map[int]string{0: "0 s", 59: "59 s", 60: "1 min", 3599: "59 min", 3600: "1 h 0 min", 3900: "1 h 5 min"} {
+		if got := formatDwell(seconds); got != want {
+			t.Errorf("formatDwell(%d) = %q, want %q", seconds, got, want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/geo"
+)
+
+type spotProperties struct {
+	Activity string     `json:"activity,omitempty"`
+	Start    int        `json:"start"`
+	Duration int        `json:"duration"`
+	Time     *time.Time `json:"time,omitempty"`
+}
+
+type clusterProperties struct {
+	Rank       int        `json:"rank"`
+	Visits     int        `json:"visits"`
+	Dwell      int        `json:"dwell"`
+	FirstVisit *time.Time `json:"first_visit,omitempty"`
+	LastVisit  *time.Time `json:"last_visit,omitempty"`
+	Activities []string   `json:"activities"`
+}
+
+// GeoJSON returns the stops as Point features with the activity, the offset
+// from the activity start and the duration in seconds as properties
+func (s *SpotList) GeoJSON() *geo.FeatureCollection {
+	fc := geo.NewFeatureCollection()
+	for _, spot := range s.Data {
+		fc.AddPoint(spot.Lat, spot.Lng, spotProperties{
+			Activity: spot.Activity,
+			Start:    spot.Start,
+			Duration: spot.Duration,
+			Time:     spot.Time,
+		})
+	}
+	return fc
+}
+
+func (s *SpotList) WriteGeoJSON(out io.Writer) error {
+	return json.NewEncoder(out).Encode(s.GeoJSON())
+}
+
+// GeoJSON returns the clusters as Point features in ranked order with the
+// rank, starting at 1, the visits, the dwell time in seconds and the
+// activities as properties
+func (cl *ClusterList) GeoJSON() *geo.FeatureCollection {
+	fc := geo.NewFeatureCollection()
+	for i, c := range cl.Data {
+		activities := c.Activities
+		if activities == nil {
+			activities = make([]string, 0)
+		}
+		fc.AddPoint(c.Lat, c.Lng, clusterProperties{
+			Rank:       i + 1,
+			Visits:     c.Visits,
+			Dwell:      c.Dwell,
+			FirstVisit: c.FirstVisit,
+			LastVisit:  c.LastVisit,
+			Activities: activities,
+		})
+	}
+	return fc
+}
+
+func (cl *ClusterList) WriteGeoJSON(out io.Writer) error {
+	return json.NewEncoder(out).Encode(cl.GeoJSON())
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// featureCollection is the shape of the GeoJSON output as a client reads it
+type featureCollection struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string    `json:"type"`
+			Coordinates []float64 `json:"coordinates"`
+		} `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	} `json:"features"`
+}
+
+func readFeatureCollection(t *testing.T, write func(*bytes.Buffer) error) featureCollection {
+	t.Helper()
+	var out bytes.Buffer
+	if err := write(&out); err != nil {
+		t.Fatal(err)
+	}
+	var fc featureCollection
+	if err := json.Unmarshal(out.Bytes(), &fc); err != nil {
+		t.Fatalf("invalid geojson %s: %v", out.String(), err)
+	}
+	if fc.Type != "FeatureCollection" {
+		t.Fatalf("type = %q, want FeatureCollection", fc.Type)
+	}
+	for i, f := range fc.Features {
+		if f.Type != "Feature" || f.Geometry.Type != "Point" || len(f.Geometry.Coordinates) != 2 {
+			t.Fatalf("feature %d is a %q with a %q of %v, want a Feature with a Point", i, f.Type, f.Geometry.Type, f.Geometry.Coordinates)
+		}
+	}
+	return fc
+}
+
+func TestSpotListGeoJSON(t *testing.T) {
+	at := time.Date(2021, 3, 4, 8, 10, 0, 0, time.UTC)
+	sl := &SpotList{Data: []Spot{
+		{Lat: 42.69, Lng: 23.32, Start: 600, Duration: 300, Activity: "4711", Time: &at},
+		{Lat: -33.86, Lng: 151.2, Start: 60, Duration: 120},
+	}}
+	fc := readFeatureCollection(t, func(out *bytes.Buffer) error { return sl.WriteGeoJSON(out) })
+	if len(fc.Features) != 2 {
+		t.Fatalf("got %d features, want 2", len(fc.Features))
+	}
+
+	tests := []struct {
+		coordinates []float64
+		properties  map[string]interface{}
+	}{
+		{
+			coordinates: []float64{23.32, 42.69},
+			properties:  map[string]interface{}{"activity": "4711", "start": 600.0, "duration": 300.0, "time": "2021-03-04T08:10:00Z"},
+		},
+		{
+			// without an activity and a time
+			coordinates: []float64{151.2, -33.86},
+			properties:  map[string]interface{}{"start": 60.0, "duration": 120.0},
+		},
+	}
+	for i, tt := range tests {
+		f := fc.Features[i]
+		if !reflect.DeepEqual(f.Geometry.Coordinates, tt.coordinates) {
+			t.Errorf("feature %d at %v, want [lng, lat] %v", i, f.Geometry.Coordinates, tt.coordinates)
+		}
+		if !reflect.DeepEqual(f.Properties, tt.properties) {
+			t.Errorf("feature %d properties = %v, want %v", i, f.Properties, tt.properties)
+		}
+	}
+}
+
+func TestClusterListGeoJSON(t *testing.T) {
+	first := time.Date(2020, 5, 1, 9, 0, 0, 0, time.UTC)
+	last := time.Date(2021, 3, 4, 8, 10, 0, 0, time.UTC)
+	cl := &ClusterList{Data: []Cluster{
+		{Lat: 42.69, Lng: 23.32, Visits: 3, Dwell: 900, FirstVisit: &first, LastVisit: &last, Activities: []string{"1", "2", "3"}},
+		{Lat: 42.7, Lng: 23.4, Visits: 1, Dwell: 120},
+	}}
+	fc := readFeatureCollection(t, func(out *bytes.Buffer) error { return cl.WriteGeoJSON(out) })
+	if len(fc.Features) != 2 {
+		t.Fatalf("got %d features, want 2", len(fc.Features))
+	}
+
+	tests := []struct {
+		coordinates []float64
+		properties  map[string]interface{}
+	}{
+		{
+			coordinates: []float64{23.32, 42.69},
+			properties: map[string]interface{}{
+				"rank": 1.0, "visits": 3.0, "dwell": 900.0,
+				"first_visit": "2020-05-01T09:00:00Z", "last_visit": "2021-03-04T08:10:00Z",
+				"activities": []interface{}{"1", "2", "3"},
+			},
+		},
+		{
+			// activities are an empty list rather than null, unknown visit
+			// times are left out
+			coordinates: []float64{23.4, 42.7},
+			properties:  map[string]interface{}{"rank": 2.0, "visits": 1.0, "dwell": 120.0, "activities": []interface{}{}},
+		},
+	}
+	for i, tt := range tests {
+		f := fc.Features[i]
+		if !reflect.DeepEqual(f.Geometry.Coordinates, tt.coordinates) {
+			t.Errorf("feature %d at %v, want [lng, lat] %v", i, f.Geometry.Coordinates, tt.coordinates)
+		}
+		if !reflect.DeepEqual(f.Properties, tt.properties) {
+			t.Errorf("feature %d properties = %v, want %v", i, f.Properties, tt.properties)
+		}
+	}
+}
+
+func TestEmptyGeoJSON(t *testing.T) {
+	fc := readFeatureCollection(t, func(out *bytes.Buffer) error { return (&SpotList{}).WriteGeoJSON(out) })
+	if fc.Features == nil || len(fc.Features) != 0 {
+		t.Errorf("features = %v, want an empty list", fc.Features)
+	}
+}
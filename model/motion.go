@@ -0,0 +1,74 @@
+package model
+
+import "github.com/IcoBoyanov/lazy-spots/geo"
+
+// MotionClassifier derives the moving stream from positions and times, for
+// activities recorded without one. The speed of a sample is measured over the
+// last Window seconds, so a single noisy position does not start or end a
+// stop. A stopped rider starts moving above StartSpeed and a moving rider
+// stops below StopSpeed, so riding slowly does not flip between the two.
+// Displacements within Jitter meters are GPS noise of a standing device and
+// do not start a ride, over a full window a stopped rider starts above the
+// larger of StartSpeed and Jitter/Window. Jitter does not apply to a moving
+// rider, who always stops below StopSpeed.
+type MotionClassifier struct {
+	// StartSpeed and StopSpeed are in meters per second
+	StartSpeed float64
+	StopSpeed  float64
+	// Jitter is in meters
+	Jitter float64
+	// Window is in seconds
+	Window int
+}
+
+// DefaultMotionClassifier suits cycling with a phone or a bike computer
+var DefaultMotionClassifier = MotionClassifier{
+	StartSpeed: 1.5,
+	StopSpeed:  0.8,
+	Jitter:     10,
+	Window:     10,
+}
+
+// Classify returns whether the rider was moving at each position. Without a
+// time stream the sample index is used as the time in seconds.
+func (c MotionClassifier) Classify(latlng LatLngStream, times IntStream) BoolStream {
+	n := len(latlng)
+	if times != nil && len(times) < n {
+		n = len(times)
+	}
+	offset := func(i int) int {
+		if times == nil {
+			return i
+		}
+		return times[i]
+	}
+
+	moving := make(BoolStream, n)
+	state := false
+	// j is the latest sample at least Window seconds before i
+	j := 0
+	for i := 1; i < n; i++ {
+		for j+1 < i && offset(i)-offset(j+1) >= c.Window {
+			j++
+		}
+		dt := offset(i) - offset(j)
+		if dt <= 0 {
+			moving[i] = state
+			continue
+		}
+
+		meters := geo.Distance(latlng[j][0], latlng[j][1], latlng[i][0], latlng[i][1])
+		speed := meters / float64(dt)
+		if state && speed < c.StopSpeed {
+			state = false
+		} else if !state && speed > c.StartSpeed && meters > c.Jitter {
+			state = true
+		}
+		moving[i] = state
+	}
+	// The first sample has no speed, it is in the state of the second
+	if n > 1 {
+		moving[0] = moving[1]
+	}
+	return moving
+}
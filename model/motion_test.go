@@ -0,0 +1,135 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/IcoBoyanov/lazy-spots/geo"
+)
+
+// phase is ridden north at a constant speed in meters per second
+type phase struct {
+	speed   float64
+	seconds int
+}
+
+// ride samples the phases once a second
+func ride(phases ...phase) (LatLngStream, IntStream) {
+	latlng := LatLngStream{{42.69, 23.32}}
+	times := IntStream{0}
+	for _, p := range phases {
+		for s := 0; s < p.seconds; s++ {
+			last := latlng[len(latlng)-1]
+			latlng = append(latlng, [2]float64{last[0] + p.speed/geo.MetersPerDegree, last[1]})
+			times = append(times, times[len(times)-1]+1)
+		}
+	}
+	return latlng, times
+}
+
+func TestMotionClassifierThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		classifier MotionClassifier
+		phases     []phase
+		// want is whether the rider moves at the end of every phase
+		want []bool
+	}{
+		{
+			name:   "riding",
+			phases: []phase{{5, 60}},
+			want:   []bool{true},
+		},
+		{
+			name:   "stopping below StopSpeed",
+			phases: []phase{{5, 60}, {0.7, 60}},
+			want:   []bool{true, false},
+		},
+		{
+			name:   "riding slowly above StopSpeed keeps moving",
+			phases: []phase{{5, 60}, {0.9, 60}},
+			want:   []bool{true, true},
+		},
+		{
+			name:   "walking between the thresholds does not start a ride",
+			phases: []phase{{0, 60}, {1.2, 60}},
+			want:   []bool{false, false},
+		},
+		{
+			name:   "walking between the thresholds does not end a ride",
+			phases: []phase{{5, 60}, {1.2, 60}},
+			want:   []bool{true, true},
+		},
+		{
+			name:   "starting above StartSpeed",
+			phases: []phase{{0, 60}, {1.6, 60}},
+			want:   []bool{false, true},
+		},
+		{
+			name:   "riding on after a stop",
+			phases: []phase{{5, 60}, {0, 120}, {5, 60}},
+			want:   []bool{true, false, true},
+		},
+		{
+			name:       "Jitter over Window above StartSpeed raises the start speed",
+			classifier: MotionClassifier{StartSpeed: 1.5, StopSpeed: 0.8, Jitter: 30, Window: 10},
+			phases:     []phase{{0, 60}, {2.5, 60}, {3.5, 60}},
+			want:       []bool{false, false, true},
+		},
+		{
+			name:       "Jitter does not raise the stop speed",
+			classifier: MotionClassifier{StartSpeed: 1.5, StopSpeed: 0.8, Jitter: 30, Window: 10},
+			phases:     []phase{{5, 60}, {1, 60}},
+			want:       []bool{true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			classifier := tt.classifier
+			if classifier == (MotionClassifier{}) {
+				classifier = DefaultMotionClassifier
+			}
+			latlng, times := ride(tt.phases...)
+			moving := classifier.Classify(latlng, times)
+			if len(moving) != len(latlng) {
+				t.Fatalf("got %d samples, want %d", len(moving), len(latlng))
+			}
+			var got []bool
+			end := 0
+			for _, p := range tt.phases {
+				end += p.seconds
+				got = append(got, moving[end])
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("moving at the end of the phases = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A standing device's positions wander a few meters, which is fast over a
+// second but within Jitter
+func TestMotionClassifierJitter(t *testing.T) {
+	latlng := make(LatLngStream, 60)
+	times := make(IntStream, 60)
+	for i := range latlng {
+		angle := float64(i) * 2.4
+		latlng[i] = [2]float64{42.69 + 4*math.Sin(angle)/geo.MetersPerDegree, 23.32 + 4*math.Cos(angle)/(geo.MetersPerDegree*0.743)}
+		times[i] = i
+	}
+	for i, moving := range DefaultMotionClassifier.Classify(latlng, times) {
+		if moving {
+			t.Fatalf("standing device is moving at sample %d", i)
+		}
+	}
+}
+
+func TestMotionClassifierWithoutTimes(t *testing.T) {
+	latlng, times := ride(phase{0, 30}, phase{5, 30})
+	withTimes := DefaultMotionClassifier.Classify(latlng, times)
+	withoutTimes := DefaultMotionClassifier.Classify(latlng, nil)
+	if fmt.Sprint(withTimes) != fmt.Sprint(withoutTimes) {
+		t.Errorf("without times the sample index is not used as the time")
+	}
+}
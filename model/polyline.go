@@ -0,0 +1,41 @@
+package model
+
+import "fmt"
+
+// DecodePolyline decodes a Google encoded polyline, the format of Strava's
+// summary_polyline, into [lat, lng] points
+// https://developers.google.com/maps/documentation/utilities/polylinealgorithm
+func DecodePolyline(encoded string) (LatLngStream, error) {
+	points := make(LatLngStream, 0)
+	var lat, lng int
+	for i := 0; i < len(encoded); {
+		var deltas [2]int
+		for d := range deltas {
+			var result, shift uint
+			for {
+				if i >= len(encoded) {
+					return nil, fmt.Errorf("polyline ends in the middle of a point")
+				}
+				if encoded[i] < 63 || encoded[i] > 126 {
+					return nil, fmt.Errorf("invalid polyline character %q at %d", encoded[i], i)
+				}
+				b := uint(encoded[i]) - 63
+				i++
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+			}
+			if result&1 != 0 {
+				deltas[d] = ^int(result >> 1)
+			} else {
+				deltas[d] = int(result >> 1)
+			}
+		}
+		lat += deltas[0]
+		lng += deltas[1]
+		points = append(points, [2]float64{float64(lat) / 1e5, float64(lng) / 1e5})
+	}
+	return points, nil
+}
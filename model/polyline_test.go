@@ -0,0 +1,60 @@
+package model
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecodePolyline(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		want    LatLngStream
+	}{
+		{
+			// https://developers.google.com/maps/documentation/utilities/polylinealgorithm
+			name:    "reference polyline",
+			encoded: "_p~iF~ps|U_ulLnnqC_mqNvxq`@",
+			want:    LatLngStream{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}},
+		},
+		{
+			name:    "origin",
+			encoded: "??",
+			want:    LatLngStream{{0, 0}},
+		},
+		{
+			name:    "empty",
+			encoded: "",
+			want:    LatLngStream{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodePolyline(tt.encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == nil || len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i][0]-tt.want[i][0]) > 1e-9 || math.Abs(got[i][1]-tt.want[i][1]) > 1e-9 {
+					t.Errorf("point %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecodePolylineErrors(t *testing.T) {
+	for name, encoded := range map[string]string{
+		"latitude only":          "_p~iF",
+		"ends in a longitude":    "_p~iF~ps|",
+		"ends in the next point": "_p~iF~ps|U_ulL",
+		"invalid character":      "_p~iF ~ps|U",
+	} {
+		if _, err := DecodePolyline(encoded); err == nil {
+			t.Errorf("%s: expected an error for %q", name, encoded)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/IcoBoyanov/lazy-spots/geo"
+)
+
+// MatchMode selects which points of an activity must be inside a geofence
+type MatchMode string
+
+const (
+	MatchStart MatchMode = "start"
+	MatchEnd   MatchMode = "end"
+	MatchAny   MatchMode = "any"
+)
+
+// Region is a named area given either as a bounding box in GeoJSON order
+// [west, south, east, north], as GeoJSON polygon coordinates or as a GeoJSON
+// file with polygons or multipolygons
+type Region struct {
+	Name    string      `json:"name"`
+	BBox    []float64   `json:"bbox,omitempty"`
+	Polygon geo.Polygon `json:"polygon,omitempty"`
+	File    string      `json:"file,omitempty"`
+
+	fence geo.Bounded
+}
+
+// RegionFilter keeps activities inside any of its regions. A nil, disabled
+// or empty filter keeps every activity.
+type RegionFilter struct {
+	Disabled bool      `json:"disabled"`
+	Match    MatchMode `json:"match"`
+	Regions  []Region  `json:"regions"`
+}
+
+// LoadRegionFilter reads a JSON region filter. Relative GeoJSON file paths
+// are resolved against the directory of the filter file.
+func LoadRegionFilter(path string) (*RegionFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open region filter: %v", err)
+	}
+	defer f.Close()
+
+	var rf RegionFilter
+	if err := json.NewDecoder(f).Decode(&rf); err != nil {
+		return nil, fmt.Errorf("could not parse region filter: %v", err)
+	}
+	if rf.Match == "" {
+		rf.Match = MatchEnd
+	}
+	if err := rf.validate(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	return &rf, nil
+}
+
+func (rf *RegionFilter) validate(dir string) error {
+	switch rf.Match {
+	case MatchStart, MatchEnd, MatchAny:
+	default:
+		return fmt.Errorf("unknown match mode '%s', expected one of start, end or any", rf.Match)
+	}
+
+	for i := range rf.Regions {
+		r := &rf.Regions[i]
+		switch {
+		case r.File != "":
+			file := r.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(dir, file)
+			}
+			fence, err := geo.LoadGeoJSON(file)
+			if err != nil {
+				return fmt.Errorf("region '%s': %v", r.Name, err)
+			}
+			r.fence = fence
+		case r.BBox != nil:
+			if len(r.BBox) != 4 {
+				return fmt.Errorf("region '%s': bbox needs 4 values [west, south, east, north]", r.Name)
+			}
+			if r.BBox[0] > r.BBox[2] || r.BBox[1] > r.BBox[3] {
+				return fmt.Errorf("region '%s': bbox %v is not in [west, south, east, north] order", r.Name, r.BBox)
+			}
+			r.fence = geo.BBox{r.BBox[0], r.BBox[1], r.BBox[2], r.BBox[3]}
+		case len(r.Polygon) > 0:
+			if err := r.Polygon.Validate(); err != nil {
+				return fmt.Errorf("region '%s': %v", r.Name, err)
+			}
+			r.fence = r.Polygon
+		default:
+			return fmt.Errorf("region '%s' has neither a bbox, a polygon nor a file", r.Name)
+		}
+	}
+	return nil
+}
+
+// Geofence returns the area of the region
+func (r Region) Geofence() geo.Bounded {
+	if r.fence != nil {
+		return r.fence
+	}
+	if len(r.BBox) == 4 {
+		return geo.BBox{r.BBox[0], r.BBox[1], r.BBox[2], r.BBox[3]}
+	}
+	return r.Polygon
+}
+
+func (rf *RegionFilter) enabled() bool {
+	return rf != nil && !rf.Disabled && len(rf.Regions) > 0
+}
+
+// Contains reports whether the point is inside any of the regions
+func (rf *RegionFilter) Contains(lat, lng float64) bool {
+	if !rf.enabled() {
+		return true
+	}
+	for _, r := range rf.Regions {
+		if r.Geofence().Contains(lat, lng) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the activities matching the regions
+func (rf *RegionFilter) Filter(as *ActivitySummaryList) *ActivitySummaryList {
+	if !rf.enabled() {
+		return as
+	}
+	return as.Within(rf, rf.Match)
+}
+
+// Region returns the geofence of the named region
+func (rf *RegionFilter) Region(name string) (geo.Bounded, bool) {
+	if rf == nil {
+		return nil, false
+	}
+	for _, r := range rf.Regions {
+		if r.Name == name {
+			return r.Geofence(), true
+		}
+	}
+	return nil, false
+}
+
+// Center returns the center of the first region's bounds
+func (rf *RegionFilter) Center() (lat float64, lng float64, ok bool) {
+	if !rf.enabled() {
+		return 0, 0, false
+	}
+	lat, lng = rf.Regions[0].Geofence().Bounds().Center()
+	return lat, lng, true
+}
@@ -0,0 +1,158 @@
+package model
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The reference polyline goes from (38.5, -120.2) through (40.7, -120.95) to
+// (43.252, -126.453), only its middle point is in the test regions
+const throughRegion = "_p~iF~ps|U_ulLnnqC_mqNvxq`@"
+
+func regionActivities() *ActivitySummaryList {
+	summary := func(id int, start, end [2]float64, polyline string) ActivitySummary {
+		s := ActivitySummary{ID: id, Start: start, End: end}
+		s.Map.SummaryPolyline = polyline
+		return s
+	}
+	return &ActivitySummaryList{SumamryList: []ActivitySummary{
+		summary(1, [2]float64{40.7, -120.95}, [2]float64{0, 0}, ""),
+		summary(2, [2]float64{0, 0}, [2]float64{40.8, -121}, ""),
+		summary(3, [2]float64{38.5, -120.2}, [2]float64{43.252, -126.453}, throughRegion),
+		summary(4, [2]float64{0, 0}, [2]float64{0, 0}, ""),
+		// an invalid polyline falls back to the start and the end
+		summary(5, [2]float64{40.7, -120.95}, [2]float64{0, 0}, "_p~iF"),
+	}}
+}
+
+func ids(as *ActivitySummaryList) []int {
+	ids := []int{}
+	for _, s := range as.SumamryList {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+// writeFile writes content to name in dir and returns its path
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRegionFilterMatch(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "region.geojson", `{"type":"Polygon","coordinates":[[[-121.5,40],[-120.5,40],[-120.5,41],[-121.5,41],[-121.5,40]]]}`)
+
+	regions := map[string]string{
+		"bbox":    `{"name":"sierra","bbox":[-121.5,40,-120.5,41]}`,
+		"polygon": `{"name":"sierra","polygon":[[[-121.5,40],[-120.5,40],[-120.5,41],[-121.5,41],[-121.5,40]]]}`,
+		"file":    `{"name":"sierra","file":"region.geojson"}`,
+	}
+	tests := []struct {
+		match string
+		want  []int
+	}{
+		{`"match":"start",`, []int{1, 5}},
+		{`"match":"end",`, []int{2}},
+		{`"match":"any",`, []int{1, 2, 3, 5}},
+		// the end by default
+		{``, []int{2}},
+	}
+	for kind, region := range regions {
+		for _, tt := range tests {
+			path := writeFile(t, dir, "filter.json", `{`+tt.match+`"regions":[`+region+`]}`)
+			rf, err := LoadRegionFilter(path)
+			if err != nil {
+				t.Fatalf("%s %s: %v", kind, tt.match, err)
+			}
+			if got := ids(rf.Filter(regionActivities())); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s %s: kept %v, want %v", kind, tt.match, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestRegionFilterKeepsEverything(t *testing.T) {
+	all := ids(regionActivities())
+	tests := []struct {
+		name string
+		rf   *RegionFilter
+	}{
+		{"nil", nil},
+		{"no regions", &RegionFilter{Match: MatchAny}},
+		{"disabled", &RegionFilter{Disabled: true, Match: MatchStart, Regions: []Region{{Name: "sierra", BBox: []float64{-121.5, 40, -120.5, 41}}}}},
+	}
+	for _, tt := range tests {
+		if got := ids(tt.rf.Filter(regionActivities())); !reflect.DeepEqual(got, all) {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, all)
+		}
+		if !tt.rf.Contains(0, 0) {
+			t.Errorf("%s: does not contain a point", tt.name)
+		}
+		if _, _, ok := tt.rf.Center(); ok {
+			t.Errorf("%s: has a center", tt.name)
+		}
+	}
+}
+
+func TestRegionFilterRegions(t *testing.T) {
+	rf := &RegionFilter{Match: MatchEnd, Regions: []Region{
+		{Name: "sierra", BBox: []float64{-121.5, 40, -120.5, 41}},
+		{Name: "sofia", BBox: []float64{23.2, 42.6, 23.4, 42.8}},
+	}}
+	if !rf.Contains(42.7, 23.3) || rf.Contains(0, 0) {
+		t.Error("contains the wrong points")
+	}
+	if lat, lng, ok := rf.Center(); !ok || lat != 40.5 || lng != -121 {
+		t.Errorf("center = %g, %g, %v, want the center of the first region", lat, lng, ok)
+	}
+	fence, ok := rf.Region("sofia")
+	if !ok || !fence.Contains(42.7, 23.3) {
+		t.Errorf("region sofia = %v, %v", fence, ok)
+	}
+	if _, ok := rf.Region("plovdiv"); ok {
+		t.Error("found an unknown region")
+	}
+}
+
+func TestLoadRegionFilterErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "point.geojson", `{"type":"Point","coordinates":[23.3,42.7]}`)
+
+	tests := []struct {
+		name    string
+		filter  string
+		wantErr string
+	}{
+		{"not json", `{"regions":`, "could not parse region filter"},
+		{"unknown match mode", `{"match":"middle","regions":[]}`, "unknown match mode 'middle'"},
+		{"region without an area", `{"regions":[{"name":"sofia"}]}`, "region 'sofia' has neither"},
+		{"bbox with 3 values", `{"regions":[{"name":"sofia","bbox":[23.2,42.6,23.4]}]}`, "bbox needs 4 values"},
+		{"bbox with east and west swapped", `{"regions":[{"name":"sofia","bbox":[23.4,42.6,23.2,42.8]}]}`, "not in [west, south, east, north] order"},
+		{"too short polygon", `{"regions":[{"name":"sofia","polygon":[[[23.2,42.6],[23.4,42.6],[23.2,42.6]]]}]}`, "region 'sofia': polygon ring has 3 positions"},
+		{"unclosed polygon", `{"regions":[{"name":"sofia","polygon":[[[23.2,42.6],[23.4,42.6],[23.4,42.8],[23.2,42.8]]]}]}`, "region 'sofia': polygon ring is not closed"},
+		{"polygon without rings", `{"regions":[{"name":"sofia","polygon":[[]]}]}`, "region 'sofia'"},
+		{"missing file", `{"regions":[{"name":"sofia","file":"missing.geojson"}]}`, "region 'sofia'"},
+		{"file without polygons", `{"regions":[{"name":"sofia","file":"point.geojson"}]}`, "region 'sofia'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, dir, "filter.json", tt.filter)
+			_, err := LoadRegionFilter(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := LoadRegionFilter(filepath.Join(dir, "missing.json")); err == nil || !strings.Contains(err.Error(), "could not open region filter") {
+		t.Errorf("error = %v for a missing filter", err)
+	}
+}
@@ -7,17 +7,159 @@ import (
 	"io"
 )
 
+// Strava stream types used to detect stops
+const (
+	StreamTypeMoving = "moving"
+	StreamTypeLatLng = "latlng"
+	StreamTypeTime   = "time"
+)
+
 type ActivityStream struct {
 	Streams []StreamData `json:"streams"`
 }
 
-// Streams set one of {ditance, moving, latlng}
+// Streams set one of {ditance, moving, latlng, time}
 // https://developers.strava.com/docs/reference/#api-models-StreamSet
 type StreamData struct {
-	Type       string        `json:"type"`
-	Resolution string        `json:"resolution"`
-	Size       int           `json:"original_size"`
-	Data       []interface{} `json:"data"`
+	Type       string `json:"type"`
+	Resolution string `json:"resolution"`
+	Size       int    `json:"original_size"`
+	Data       Stream `json:"data"`
+}
+
+// Stream is the typed data of a StreamData
+type Stream interface {
+	Len() int
+}
+
+type LatLngStream [][2]float64
+type BoolStream []bool
+type FloatStream []float64
+type IntStream []int
+
+func (s LatLngStream) Len() int { return len(s) }
+func (s BoolStream) Len() int   { return len(s) }
+func (s FloatStream) Len() int  { return len(s) }
+func (s IntStream) Len() int    { return len(s) }
+
+// streamKinds maps Strava stream types to a decoder of their data
+var streamKinds = map[string]func(json.RawMessage) (Stream, error){
+	"latlng":          decodeLatLng,
+	"moving":          decodeBool,
+	"time":            decodeInt,
+	"heartrate":       decodeInt,
+	"cadence":         decodeInt,
+	"watts":           decodeInt,
+	"temp":            decodeInt,
+	"distance":        decodeFloat,
+	"altitude":        decodeFloat,
+	"velocity_smooth": decodeFloat,
+	"grade_smooth":    decodeFloat,
+}
+
+func (sd *StreamData) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Type       string          `json:"type"`
+		Resolution string          `json:"resolution"`
+		Size       int             `json:"original_size"`
+		Data       json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	sd.Type = raw.Type
+	sd.Resolution = raw.Resolution
+	sd.Size = raw.Size
+	sd.Data = nil
+
+	// Streams Strava adds later are not decoded, they have no data and are
+	// dropped with the activity's empty streams
+	decode, ok := streamKinds[raw.Type]
+	if !ok {
+		return nil
+	}
+	data, err := decode(raw.Data)
+	if err != nil {
+		return fmt.Errorf("invalid '%s' stream: %v", raw.Type, err)
+	}
+	sd.Data = data
+	return nil
+}
+
+func decodeLatLng(b json.RawMessage) (Stream, error) {
+	var points [][]float64
+	if err := json.Unmarshal(b, &points); err != nil {
+		return nil, err
+	}
+	result := make(LatLngStream, len(points))
+	for i, p := range points {
+		if len(p) != 2 {
+			return nil, fmt.Errorf("sample %d has %d coordinates, expected 2", i, len(p))
+		}
+		result[i] = [2]float64{p[0], p[1]}
+	}
+	return result, nil
+}
+
+func decodeBool(b json.RawMessage) (Stream, error) {
+	var result BoolStream
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func decodeInt(b json.RawMessage) (Stream, error) {
+	var result IntStream
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func decodeFloat(b json.RawMessage) (Stream, error) {
+	var result FloatStream
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (as *ActivityStream) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Streams []StreamData `json:"streams"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	// Strava sends some streams of an activity empty or null, e.g. moving for
+	// activities recorded without it. They are dropped rather than checked
+	// against the length of the other streams.
+	as.Streams = nil
+	for _, sd := range raw.Streams {
+		if sd.Data == nil || sd.Data.Len() == 0 {
+			continue
+		}
+		if err := as.Add(sd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add appends a stream, all streams of an activity must have the same length.
+// A stream without samples, also a typed nil one, is an error.
+func (as *ActivityStream) Add(sd StreamData) error {
+	if sd.Data == nil || sd.Data.Len() == 0 {
+		return fmt.Errorf("stream '%s' has no data", sd.Type)
+	}
+	if len(as.Streams) > 0 && as.Streams[0].Data.Len() != sd.Data.Len() {
+		return fmt.Errorf("stream '%s' has %d samples, expected %d", sd.Type, sd.Data.Len(), as.Streams[0].Data.Len())
+	}
+	as.Streams = append(as.Streams, sd)
+	return nil
 }
 
 func NewActivityStream(r io.Reader) (*ActivityStream, error) {
@@ -32,6 +174,51 @@ func NewActivityStream(r io.Reader) (*ActivityStream, error) {
 	return &as, nil
 }
 
+// Stream returns the stream of the given type or nil if it was not collected
+func (as *ActivityStream) Stream(streamType string) *StreamData {
+	for i := range as.Streams {
+		if as.Streams[i].Type == streamType {
+			return &as.Streams[i]
+		}
+	}
+	return nil
+}
+
+// Len returns the number of samples in the activity
+func (as *ActivityStream) Len() int {
+	if len(as.Streams) == 0 {
+		return 0
+	}
+	return as.Streams[0].Data.Len()
+}
+
+// LatLng returns the latlng stream or nil if it was not collected
+func (as *ActivityStream) LatLng() LatLngStream {
+	if sd := as.Stream(StreamTypeLatLng); sd != nil {
+		data, _ := sd.Data.(LatLngStream)
+		return data
+	}
+	return nil
+}
+
+// Moving returns the moving stream or nil if it was not collected
+func (as *ActivityStream) Moving() BoolStream {
+	if sd := as.Stream(StreamTypeMoving); sd != nil {
+		data, _ := sd.Data.(BoolStream)
+		return data
+	}
+	return nil
+}
+
+// Time returns the time stream or nil if it was not collected
+func (as *ActivityStream) Time() IntStream {
+	if sd := as.Stream(StreamTypeTime); sd != nil {
+		data, _ := sd.Data.(IntStream)
+		return data
+	}
+	return nil
+}
+
 func (as *ActivityStream) Write(out io.Writer) error {
 	return json.NewEncoder(out).Encode(as)
 }
@@ -0,0 +1,170 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// streams wraps Strava's stream objects the way GetRide does
+func streams(objects ...string) string {
+	return `{"streams":[` + strings.Join(objects, ",") + `]}`
+}
+
+func stream(streamType, data string) string {
+	return fmt.Sprintf(`{"type":%q,"data":%s,"series_type":"distance","original_size":2,"resolution":"high"}`, streamType, data)
+}
+
+func TestNewActivityStream(t *testing.T) {
+	latlng := stream("latlng", `[[42.69,23.32],[42.7,23.33]]`)
+	times := stream("time", `[0,5]`)
+
+	tests := []struct {
+		name    string
+		json    string
+		want    map[string]Stream
+		wantErr string
+	}{
+		{
+			name: "streams of every kind",
+			json: streams(latlng, times, stream("moving", `[false,true]`), stream("distance", `[0,12.5]`)),
+			want: map[string]Stream{
+				"latlng":   LatLngStream{{42.69, 23.32}, {42.7, 23.33}},
+				"time":     IntStream{0, 5},
+				"moving":   BoolStream{false, true},
+				"distance": FloatStream{0, 12.5},
+			},
+		},
+		{
+			name: "unknown stream types are skipped",
+			json: streams(latlng, stream("power_smooth", `[1.5,2]`), stream("surface", `{"kind":"gravel"}`), stream("route", `[1,2,3]`)),
+			want: map[string]Stream{"latlng": LatLngStream{{42.69, 23.32}, {42.7, 23.33}}},
+		},
+		{
+			name: "empty and null streams are skipped",
+			json: streams(latlng, stream("moving", `[]`), stream("time", `null`)),
+			want: map[string]Stream{"latlng": LatLngStream{{42.69, 23.32}, {42.7, 23.33}}},
+		},
+		{
+			name: "no streams",
+			json: `{"streams":[]}`,
+			want: map[string]Stream{},
+		},
+		{
+			name:    "position with a string",
+			json:    streams(stream("latlng", `[[42.69,"23.32"]]`)),
+			wantErr: "invalid 'latlng' stream",
+		},
+		{
+			name:    "position with one coordinate",
+			json:    streams(stream("latlng", `[[42.69,23.32],[42.7]]`)),
+			wantErr: "sample 1 has 1 coordinates",
+		},
+		{
+			name:    "position with three coordinates",
+			json:    streams(stream("latlng", `[[42.69,23.32,550]]`)),
+			wantErr: "sample 0 has 3 coordinates",
+		},
+		{
+			name:    "flat positions",
+			json:    streams(stream("latlng", `[42.69,23.32]`)),
+			wantErr: "invalid 'latlng' stream",
+		},
+		{
+			name:    "moving as numbers",
+			json:    streams(stream("moving", `[0,1]`)),
+			wantErr: "invalid 'moving' stream",
+		},
+		{
+			name:    "fractional time",
+			json:    streams(stream("time", `[0,1.5]`)),
+			wantErr: "invalid 'time' stream",
+		},
+		{
+			name:    "distance as booleans",
+			json:    streams(stream("distance", `[true]`)),
+			wantErr: "invalid 'distance' stream",
+		},
+		{
+			name:    "data is not an array",
+			json:    streams(stream("heartrate", `{"0":120}`)),
+			wantErr: "invalid 'heartrate' stream",
+		},
+		{
+			name:    "streams of different lengths",
+			json:    streams(latlng, stream("time", `[0,5,10]`)),
+			wantErr: "stream 'time' has 3 samples, expected 2",
+		},
+		{
+			name:    "not json",
+			json:    `{"streams":[`,
+			wantErr: "could not parse activity stream",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			as, err := NewActivityStream(strings.NewReader(tt.json))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]Stream)
+			for _, sd := range as.Streams {
+				got[sd.Type] = sd.Data
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("streams = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActivityStreamRoundTrip(t *testing.T) {
+	as, err := NewActivityStream(strings.NewReader(streams(
+		stream("latlng", `[[42.69,23.32],[42.7,23.33]]`),
+		stream("time", `[0,5]`),
+		stream("moving", `[false,true]`),
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NewActivityStream(as.Reader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(as, again) {
+		t.Errorf("stored stream reads back as %v, want %v", again, as)
+	}
+	if len(again.LatLng()) != 2 || len(again.Time()) != 2 || len(again.Moving()) != 2 || again.Len() != 2 {
+		t.Errorf("typed streams are not restored: %v", again)
+	}
+}
+
+func TestActivityStreamAdd(t *testing.T) {
+	var as ActivityStream
+	if err := as.Add(StreamData{Type: StreamTypeLatLng, Data: LatLngStream{{1, 2}, {3, 4}}}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		sd   StreamData
+	}{
+		{"no data", StreamData{Type: StreamTypeTime}},
+		{"typed nil data", StreamData{Type: StreamTypeMoving, Data: BoolStream(nil)}},
+		{"different length", StreamData{Type: StreamTypeTime, Data: IntStream{0, 1, 2}}},
+	}
+	for _, tt := range tests {
+		if err := as.Add(tt.sd); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+	if len(as.Streams) != 1 {
+		t.Errorf("got %d streams after failed adds, want 1", len(as.Streams))
+	}
+}
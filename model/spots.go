@@ -5,32 +5,141 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/geo"
 )
 
+// Spot is a single stop: the centroid of a non-moving segment of an activity,
+// the offset in seconds from the activity start and how long it lasted. Time
+// is left out when the start of the activity is unknown.
 type Spot struct {
-	Lat float64 `json:"lat"`
-	Lng float64 `json:"lng"`
+	Lat      float64    `json:"lat"`
+	Lng      float64    `json:"lng"`
+	Start    int        `json:"start"`
+	Duration int        `json:"duration"`
+	Activity string     `json:"activity,omitempty"`
+	Time     *time.Time `json:"time,omitempty"`
 }
 
 type SpotList struct {
 	Data []Spot `json:"data"`
 }
 
-const MovingStreamIdx = 0
-const LatLngStreamIdx = 1
+// DefaultMinStopDuration filters out short pauses such as traffic lights
+const DefaultMinStopDuration = 2 * time.Minute
+
+// StopDetector finds contiguous non-moving segments in activity streams.
+// Segments shorter than MinDuration are dropped. Without a time stream the
+// sample index is used as the time in seconds. Activities without a moving
+// stream are classified by Motion, DefaultMotionClassifier when it is zero.
+type StopDetector struct {
+	MinDuration time.Duration
+	Motion      MotionClassifier
+}
 
+// NewSpotList detects stops using DefaultMinStopDuration
 func NewSpotList(activities ...*ActivityStream) *SpotList {
+	return StopDetector{MinDuration: DefaultMinStopDuration}.SpotList(activities...)
+}
+
+// SpotList returns the stops of all activities
+func (d StopDetector) SpotList(activities ...*ActivityStream) *SpotList {
 	var result SpotList
 	result.Data = make([]Spot, 0)
 	for _, activity := range activities {
-		for i := range activity.Streams[0].Data {
+		result.Data = append(result.Data, d.Detect(activity)...)
+	}
+	return &result
+}
+
+// Detect returns one Spot per stop in the activity
+func (d StopDetector) Detect(activity *ActivityStream) []Spot {
+	latlng := activity.LatLng()
+	if len(latlng) == 0 {
+		return nil
+	}
+	times := activity.Time()
+	moving := activity.Moving()
+	if len(moving) == 0 {
+		moving = d.motion().Classify(latlng, times)
+	}
+
+	n := len(moving)
+	if len(latlng) < n {
+		n = len(latlng)
+	}
+	if times != nil && len(times) < n {
+		n = len(times)
+	}
 
-			if activity.Streams[MovingStreamIdx].Data[i].(bool) {
-				// This is ugly, I know
-				lat := activity.Streams[LatLngStreamIdx].Data[i].([]interface{})[0].(float64)
-				lng := activity.Streams[LatLngStreamIdx].Data[i].([]interface{})[1].(float64)
-				result.Data = append(result.Data, Spot{lat, lng})
-			}
+	offset := func(i int) int {
+		if times == nil {
+			return i
+		}
+		return times[i]
+	}
+
+	spots := make([]Spot, 0)
+	for i := 0; i < n; {
+		if moving[i] {
+			i++
+			continue
+		}
+
+		// [start, end) is a stopped segment
+		start, end := i, i
+		var lat, lng float64
+		for ; end < n && !moving[end]; end++ {
+			lat += latlng[end][0]
+			lng += latlng[end][1]
+		}
+		i = end
+
+		// A stop lasts until the rider moves again
+		last := end
+		if last == n {
+			last = n - 1
+		}
+		duration := offset(last) - offset(start)
+		if time.Duration(duration)*time.Second < d.MinDuration {
+			continue
+		}
+		count := float64(end - start)
+		spots = append(spots, Spot{
+			Lat:      lat / count,
+			Lng:      lng / count,
+			Start:    offset(start),
+			Duration: duration,
+		})
+	}
+	return spots
+}
+
+func (d StopDetector) motion() MotionClassifier {
+	if d.Motion == (MotionClassifier{}) {
+		return DefaultMotionClassifier
+	}
+	return d.Motion
+}
+
+// SetActivity records which activity the spots belong to and when they happened
+func (s *SpotList) SetActivity(activity string, start time.Time) {
+	for i := range s.Data {
+		s.Data[i].Activity = activity
+		if !start.IsZero() {
+			at := start.Add(time.Duration(s.Data[i].Start) * time.Second)
+			s.Data[i].Time = &at
+		}
+	}
+}
+
+// Within returns the spots inside the geofence
+func (s *SpotList) Within(fence geo.Geofence) *SpotList {
+	result := SpotList{Data: make([]Spot, 0)}
+	for _, spot := range s.Data {
+		if fence.Contains(spot.Lat, spot.Lng) {
+			result.Data = append(result.Data, spot)
 		}
 	}
 	return &result
@@ -43,6 +152,12 @@ func NewSpotListFromJSON(input io.Reader) (*SpotList, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not parse data: %v", err)
 	}
+	// Spots stored before times were optional hold the zero time
+	for i := range sl.Data {
+		if sl.Data[i].Time != nil && sl.Data[i].Time.IsZero() {
+			sl.Data[i].Time = nil
+		}
+	}
 
 	return &sl, nil
 }
@@ -0,0 +1,182 @@
+package model
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixture builds an activity from a string of 'M' (moving) and 'S' (stopped)
+// samples taken step seconds apart, sample i is at latitude i. Streams are
+// cut to the given lengths, a negative length leaves the stream out.
+func fixture(samples string, step, latlngLen, timeLen int) *ActivityStream {
+	moving := make(BoolStream, len(samples))
+	latlng := make(LatLngStream, len(samples))
+	times := make(IntStream, len(samples))
+	for i, c := range samples {
+		moving[i] = c == 'M'
+		latlng[i] = [2]float64{float64(i), 0}
+		times[i] = i * step
+	}
+
+	// Streams of different lengths can not be added, they are set directly
+	as := &ActivityStream{}
+	as.Streams = append(as.Streams, StreamData{Type: StreamTypeMoving, Data: moving})
+	if latlngLen >= 0 {
+		as.Streams = append(as.Streams, StreamData{Type: StreamTypeLatLng, Data: latlng[:latlngLen]})
+	}
+	if timeLen >= 0 {
+		as.Streams = append(as.Streams, StreamData{Type: StreamTypeTime, Data: times[:timeLen]})
+	}
+	return as
+}
+
+func TestStopDetectorDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		activity *ActivityStream
+		min      time.Duration
+		want     []Spot
+	}{
+		{
+			name:     "stop at the start",
+			activity: fixture("SSSSMMMM", 30, 8, 8),
+			min:      time.Minute,
+			want:     []Spot{{Lat: 1.5, Start: 0, Duration: 120}},
+		},
+		{
+			name:     "stop in the middle",
+			activity: fixture("MMSSSSMM", 30, 8, 8),
+			min:      time.Minute,
+			want:     []Spot{{Lat: 3.5, Start: 60, Duration: 120}},
+		},
+		{
+			name:     "stop at the end lasts until the last sample",
+			activity: fixture("MMMMSSSS", 30, 8, 8),
+			min:      time.Minute,
+			want:     []Spot{{Lat: 5.5, Start: 120, Duration: 90}},
+		},
+		{
+			name:     "several stops",
+			activity: fixture("SSSMMSSSMMSSS", 30, 13, 13),
+			min:      time.Minute,
+			want: []Spot{
+				{Lat: 1, Start: 0, Duration: 90},
+				{Lat: 6, Start: 150, Duration: 90},
+				{Lat: 11, Start: 300, Duration: 60},
+			},
+		},
+		{
+			name:     "pauses shorter than the minimum are dropped",
+			activity: fixture("MMSMMSSMM", 30, 9, 9),
+			min:      time.Minute,
+			want:     []Spot{{Lat: 5.5, Start: 150, Duration: 60}},
+		},
+		{
+			name:     "no stops",
+			activity: fixture("MMMMMMMM", 30, 8, 8),
+			min:      time.Minute,
+			want:     []Spot{},
+		},
+		{
+			name:     "without a time stream the index is the time",
+			activity: fixture("MSSSM", 30, 5, -1),
+			min:      3 * time.Second,
+			want:     []Spot{{Lat: 2, Start: 1, Duration: 3}},
+		},
+		{
+			name:     "a shorter latlng stream cuts the activity",
+			activity: fixture("MMSSSSSS", 30, 6, 8),
+			min:      time.Minute,
+			want:     []Spot{{Lat: 3.5, Start: 60, Duration: 90}},
+		},
+		{
+			name:     "a shorter time stream cuts the activity",
+			activity: fixture("MMSSSSSS", 30, 8, 5),
+			min:      time.Minute,
+			want:     []Spot{{Lat: 3, Start: 60, Duration: 60}},
+		},
+		{
+			name:     "without a latlng stream there are no stops",
+			activity: fixture("SSSS", 30, -1, 4),
+			min:      time.Minute,
+			want:     nil,
+		},
+		{
+			name:     "empty streams",
+			activity: fixture("", 30, 0, 0),
+			min:      time.Minute,
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := StopDetector{MinDuration: tt.min}.Detect(tt.activity)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Detect() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpotListSetActivity(t *testing.T) {
+	start := time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)
+	sl := StopDetector{MinDuration: time.Minute}.SpotList(fixture("MMSSSSMM", 30, 8, 8))
+	sl.SetActivity("42", start)
+	if len(sl.Data) != 1 {
+		t.Fatalf("got %d spots, want 1", len(sl.Data))
+	}
+	if sl.Data[0].Activity != "42" || sl.Data[0].Time == nil || !sl.Data[0].Time.Equal(start.Add(time.Minute)) {
+		t.Errorf("spot = %+v, want activity 42 at %v", sl.Data[0], start.Add(time.Minute))
+	}
+
+	// Imported rides may not know their start
+	sl = StopDetector{MinDuration: time.Minute}.SpotList(fixture("MMSSSSMM", 30, 8, 8))
+	sl.SetActivity("43", time.Time{})
+	if sl.Data[0].Activity != "43" || sl.Data[0].Time != nil {
+		t.Errorf("spot = %+v, want activity 43 without a time", sl.Data[0])
+	}
+}
+
+func TestSpotListJSON(t *testing.T) {
+	at := time.Date(2020, 5, 1, 8, 1, 0, 0, time.UTC)
+	sl := &SpotList{Data: []Spot{
+		{Lat: 1.5, Lng: 2.5, Start: 60, Duration: 120, Activity: "42", Time: &at},
+		{Lat: 3.5, Lng: 4.5, Start: 0, Duration: 180},
+	}}
+	var out bytes.Buffer
+	if err := sl.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"data":[` +
+		`{"lat":1.5,"lng":2.5,"start":60,"duration":120,"activity":"42","time":"2020-05-01T08:01:00Z"},` +
+		`{"lat":3.5,"lng":4.5,"start":0,"duration":180}]}`
+	if got := strings.TrimSpace(out.String()); got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+
+	again, err := NewSpotListFromJSON(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, sl) {
+		t.Errorf("spots read back as %+v, want %+v", again, sl)
+	}
+}
+
+func TestSpotListJSONWithZeroTime(t *testing.T) {
+	// Spots were stored with the zero time before it was left out
+	stored := `{"data":[{"lat":1.5,"lng":2.5,"start":60,"duration":120,"time":"0001-01-01T00:00:00Z"}]}`
+	sl, err := NewSpotListFromJSON(strings.NewReader(stored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.Data[0].Time != nil {
+		t.Errorf("time = %v, want none", sl.Data[0].Time)
+	}
+	if clusters := NewClusterList(sl); clusters.Data[0].FirstVisit != nil || clusters.Data[0].LastVisit != nil {
+		t.Errorf("cluster = %+v, want no visit times", clusters.Data[0])
+	}
+}
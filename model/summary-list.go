@@ -5,33 +5,32 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/geo"
 )
 
 // Types of activity streams collected
 // https://developers.strava.com/docs/reference/#api-models-StreamSet
-const ActivityStreamTypes string = "latlng,moving"
-
-// UpperBoundSofia used to filter activities in this region
-var SofiaBorder = struct {
-	lowerLeftLat  float64
-	lowerLeftLng  float64
-	upperRightLat float64
-	upperRightLng float64
-}{42.656182, 23.102273, 42.753063, 23.572252}
+const ActivityStreamTypes string = "latlng,moving,time"
 
 type ActivitySummaryList struct {
-	SumamryList []activitySummary `json:"summary-list"`
+	SumamryList []ActivitySummary `json:"summary-list"`
 }
 
 // ActivitySummary https://developers.strava.com/docs/reference/#api-models-SummaryActivity
-type activitySummary struct {
-	ID    int        `json:"id"`
-	Name  string     `json:"name"`
-	Start [2]float64 `json:"start_latlng"`
-	End   [2]float64 `json:"end_latlng"`
+type ActivitySummary struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	StartDate time.Time  `json:"start_date"`
+	Start     [2]float64 `json:"start_latlng"`
+	End       [2]float64 `json:"end_latlng"`
+	Map       struct {
+		SummaryPolyline string `json:"summary_polyline"`
+	} `json:"map"`
 }
 
-// NewActivitySummaryList reads JSON data from an io.Reader and returns a filtered []ActivitySummary
+// NewActivitySummaryList reads JSON data from an io.Reader and returns a []ActivitySummary
 func NewActivitySummaryList(input io.Reader) (*ActivitySummaryList, error) {
 	var l ActivitySummaryList
 	decoder := json.NewDecoder(input)
@@ -40,19 +39,58 @@ func NewActivitySummaryList(input io.Reader) (*ActivitySummaryList, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not parse activity list: %v", err)
 	}
-	filtered := filter(l, inBound)
 
-	return &filtered, nil
+	return &l, nil
+}
+
+// NewActivitySummaryPage reads a single page of Strava's activity list, a JSON
+// array of summaries, without filtering it
+func NewActivitySummaryPage(input io.Reader) (*ActivitySummaryList, error) {
+	var l ActivitySummaryList
+	err := json.NewDecoder(input).Decode(&l.SumamryList)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse activity list page: %v", err)
+	}
+	return &l, nil
+}
+
+// NewActivitySummary reads a single activity, Strava's DetailedActivity has
+// all fields of the summary
+func NewActivitySummary(input io.Reader) (*ActivitySummary, error) {
+	var s ActivitySummary
+	err := json.NewDecoder(input).Decode(&s)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse activity: %v", err)
+	}
+	return &s, nil
 }
 
-func inBound(as activitySummary) bool {
-	return as.End[0] > SofiaBorder.lowerLeftLat &&
-		as.End[1] > SofiaBorder.lowerLeftLng &&
-		as.End[0] < SofiaBorder.upperRightLat &&
-		as.End[1] < SofiaBorder.upperRightLng
+// Within returns the activities whose start, end or any track point, as
+// selected by match, is inside the geofence
+func (as *ActivitySummaryList) Within(fence geo.Geofence, match MatchMode) *ActivitySummaryList {
+	filtered := filter(*as, func(s ActivitySummary) bool {
+		switch match {
+		case MatchStart:
+			return fence.Contains(s.Start[0], s.Start[1])
+		case MatchAny:
+			points, err := DecodePolyline(s.Map.SummaryPolyline)
+			if err != nil || len(points) == 0 {
+				return fence.Contains(s.Start[0], s.Start[1]) || fence.Contains(s.End[0], s.End[1])
+			}
+			for _, p := range points {
+				if fence.Contains(p[0], p[1]) {
+					return true
+				}
+			}
+			return false
+		default:
+			return fence.Contains(s.End[0], s.End[1])
+		}
+	})
+	return &filtered
 }
 
-func filter(as ActivitySummaryList, test func(activitySummary) bool) (res ActivitySummaryList) {
+func filter(as ActivitySummaryList, test func(ActivitySummary) bool) (res ActivitySummaryList) {
 	for _, s := range as.SumamryList {
 		if test(s) {
 			res.SumamryList = append(res.SumamryList, s)
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DefaultCollectWorkers is the number of activities fetched concurrently
+const DefaultCollectWorkers = 4
+
+// SyncState is the high-water mark of an athlete's collected activities.
+// Only activities started after LastActivity are requested on the next sync.
+type SyncState struct {
+	Athlete      string    `json:"athlete"`
+	LastActivity time.Time `json:"last_activity"`
+	LastSync     time.Time `json:"last_sync"`
+}
+
+func NewSyncState(r io.Reader) (*SyncState, error) {
+	var s SyncState
+	err := json.NewDecoder(r).Decode(&s)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse sync state: %v", err)
+	}
+	return &s, nil
+}
+
+func (s *SyncState) Write(out io.Writer) error {
+	return json.NewEncoder(out).Encode(s)
+}
+
+func (s *SyncState) Reader() io.Reader {
+	content, _ := json.Marshal(s)
+	return bytes.NewReader(content)
+}
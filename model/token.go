@@ -0,0 +1,36 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Token is a Strava OAuth token persisted between restarts
+type Token struct {
+	Athlete      string    `json:"athlete"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	TokenType    string    `json:"token_type"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+func NewToken(r io.Reader) (*Token, error) {
+	var t Token
+	err := json.NewDecoder(r).Decode(&t)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse token: %v", err)
+	}
+	return &t, nil
+}
+
+func (t *Token) Write(out io.Writer) error {
+	return json.NewEncoder(out).Encode(t)
+}
+
+func (t *Token) Reader() io.Reader {
+	content, _ := json.Marshal(t)
+	return bytes.NewReader(content)
+}
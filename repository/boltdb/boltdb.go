@@ -0,0 +1,240 @@
+// Package boltdb stores the repository in an embedded bbolt database file, so
+// a single binary needs no external object store
+package boltdb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/IcoBoyanov/lazy-spots/repository"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Rides and map data are kept in a nested bucket per athlete, listing an
+// athlete's map data is a scan of a single bucket
+var buckets = []string{repository.RidesBucketName, repository.AthletesBucketName, repository.MapDataBucketName, repository.SyncBucketName, repository.TokensBucketName}
+
+type BoltStorageClient struct {
+	db     *bolt.DB
+	logger *log.Logger
+}
+
+// New opens or creates the database file at path. Only one process can open
+// the file at a time.
+func New(logger *log.Logger, path string) (*BoltStorageClient, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create buckets: %v", err)
+	}
+	logger.Printf("storing data in '%s'", path)
+	return &BoltStorageClient{db: db, logger: logger}, nil
+}
+
+func (b *BoltStorageClient) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltStorageClient) PostRide(athlete, ride string, data io.Reader) error {
+	return b.putRideObject(repository.RidesBucketName, athlete, ride, data)
+}
+
+func (b *BoltStorageClient) PostMapData(athlete, ride string, data io.Reader) error {
+	return b.putRideObject(repository.MapDataBucketName, athlete, ride, data)
+}
+
+// PostRideWithMapData stores the ride and its map data in one transaction
+func (b *BoltStorageClient) PostRideWithMapData(athlete, ride string, data, mapData io.Reader) error {
+	rideContent, err := ioutil.ReadAll(data)
+	if err != nil {
+		return fmt.Errorf("could not read object: %v", err)
+	}
+	mapContent, err := ioutil.ReadAll(mapData)
+	if err != nil {
+		return fmt.Errorf("could not read object: %v", err)
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := putRide(tx, repository.RidesBucketName, athlete, ride, rideContent); err != nil {
+			return err
+		}
+		return putRide(tx, repository.MapDataBucketName, athlete, ride, mapContent)
+	})
+}
+
+func (b *BoltStorageClient) PostAthlete(athlete string, data io.Reader) error {
+	return b.putObject(repository.AthletesBucketName, athlete, data)
+}
+
+func (b *BoltStorageClient) PostSyncState(athlete string, data io.Reader) error {
+	return b.putObject(repository.SyncBucketName, athlete, data)
+}
+
+func (b *BoltStorageClient) PostToken(athlete string, data io.Reader) error {
+	return b.putObject(repository.TokensBucketName, athlete, data)
+}
+
+// RemoveRide removes the ride and its map data in one transaction
+func (b *BoltStorageClient) RemoveRide(athlete, ride string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{repository.RidesBucketName, repository.MapDataBucketName} {
+			rides := tx.Bucket([]byte(bucket)).Bucket([]byte(athlete))
+			if rides == nil {
+				continue
+			}
+			if err := rides.Delete([]byte(ride)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveAthlete removes the athlete's profile, token, sync state, rides and
+// map data in one transaction
+func (b *BoltStorageClient) RemoveAthlete(athlete string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range []string{repository.RidesBucketName, repository.MapDataBucketName} {
+			err := tx.Bucket([]byte(bucket)).DeleteBucket([]byte(athlete))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		for _, bucket := range []string{repository.AthletesBucketName, repository.SyncBucketName, repository.TokensBucketName} {
+			if err := tx.Bucket([]byte(bucket)).Delete([]byte(athlete)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStorageClient) GetRide(out io.Writer, athlete, ride string) (bool, error) {
+	return b.writeObject(out, func(tx *bolt.Tx) []byte {
+		if rides := tx.Bucket([]byte(repository.RidesBucketName)).Bucket([]byte(athlete)); rides != nil {
+			return rides.Get([]byte(ride))
+		}
+		return nil
+	})
+}
+
+func (b *BoltStorageClient) HasRide(athlete, ride string) (bool, error) {
+	var found bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if rides := tx.Bucket([]byte(repository.RidesBucketName)).Bucket([]byte(athlete)); rides != nil {
+			found = rides.Get([]byte(ride)) != nil
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not read object: %v", err)
+	}
+	return found, nil
+}
+
+func (b *BoltStorageClient) GetAthlete(out io.Writer, athlete string) (bool, error) {
+	return b.getObject(out, repository.AthletesBucketName, athlete)
+}
+
+func (b *BoltStorageClient) GetSyncState(out io.Writer, athlete string) (bool, error) {
+	return b.getObject(out, repository.SyncBucketName, athlete)
+}
+
+func (b *BoltStorageClient) GetToken(out io.Writer, athlete string) (bool, error) {
+	return b.getObject(out, repository.TokensBucketName, athlete)
+}
+
+func (b *BoltStorageClient) GetAllMapPlaces(athlete string) (*model.SpotList, error) {
+	places := model.SpotList{}
+	places.Data = make([]model.Spot, 0)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		maps := tx.Bucket([]byte(repository.MapDataBucketName)).Bucket([]byte(athlete))
+		if maps == nil {
+			return nil
+		}
+		return maps.ForEach(func(k, v []byte) error {
+			sl, err := model.NewSpotListFromJSON(bytes.NewReader(v))
+			if err != nil {
+				b.logger.Printf("could not parse object: %v", err)
+				return nil
+			}
+			places.Data = append(places.Data, sl.Data...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list map data: %v", err)
+	}
+
+	return &places, nil
+}
+
+func putRide(tx *bolt.Tx, bucket, athlete, ride string, content []byte) error {
+	rides, err := tx.Bucket([]byte(bucket)).CreateBucketIfNotExists([]byte(athlete))
+	if err != nil {
+		return err
+	}
+	return rides.Put([]byte(ride), content)
+}
+
+func (b *BoltStorageClient) putRideObject(bucket, athlete, ride string, data io.Reader) error {
+	content, err := ioutil.ReadAll(data)
+	if err != nil {
+		return fmt.Errorf("could not read object: %v", err)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putRide(tx, bucket, athlete, ride, content)
+	})
+}
+
+func (b *BoltStorageClient) putObject(bucket, key string, data io.Reader) error {
+	content, err := ioutil.ReadAll(data)
+	if err != nil {
+		return fmt.Errorf("could not read object: %v", err)
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).Put([]byte(key), content)
+	})
+}
+
+func (b *BoltStorageClient) getObject(out io.Writer, bucket, key string) (bool, error) {
+	return b.writeObject(out, func(tx *bolt.Tx) []byte {
+		return tx.Bucket([]byte(bucket)).Get([]byte(key))
+	})
+}
+
+// writeObject copies the value found by get, values are only valid inside the
+// transaction
+func (b *BoltStorageClient) writeObject(out io.Writer, get func(tx *bolt.Tx) []byte) (bool, error) {
+	var found bool
+	err := b.db.View(func(tx *bolt.Tx) error {
+		content := get(tx)
+		if content == nil {
+			return nil
+		}
+		found = true
+		_, err := out.Write(content)
+		return err
+	})
+	if err != nil {
+		return found, fmt.Errorf("could not read object: %v", err)
+	}
+	return found, nil
+}
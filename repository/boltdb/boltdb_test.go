@@ -0,0 +1,55 @@
+package boltdb
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IcoBoyanov/lazy-spots/repository"
+	"github.com/IcoBoyanov/lazy-spots/repository/repotest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestRepository(t *testing.T) *BoltStorageClient {
+	repo, err := New(log.New(ioutil.Discard, "", 0), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		return newTestRepository(t)
+	})
+}
+
+// A failure after the ride is written in the transaction leaves neither the
+// ride nor its map data
+func TestPostRideWithMapDataRollsBack(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// A plain value where the athlete's map data bucket belongs makes the
+	// second write fail
+	err := repo.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(repository.MapDataBucketName)).Put([]byte("1"), []byte("not a bucket"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.PostRideWithMapData("1", "10", strings.NewReader(`{"ride":10}`), strings.NewReader(`{"data":[]}`))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	ok, err := repo.GetRide(ioutil.Discard, "1", "10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("ride was stored without its map data")
+	}
+}
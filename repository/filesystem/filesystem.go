@@ -0,0 +1,236 @@
+// Package filesystem stores the repository as JSON files in a local
+// directory, one directory per bucket
+package filesystem
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/IcoBoyanov/lazy-spots/repository"
+)
+
+const fileExtension = ".json"
+
+type FileStorageClient struct {
+	root   string
+	logger *log.Logger
+}
+
+// New stores the repository under root, which is created if missing
+func New(logger *log.Logger, root string) (repository.Repository, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, fmt.Errorf("could not create storage directory: %v", err)
+	}
+	logger.Printf("storing data in '%s'", root)
+	return &FileStorageClient{root: root, logger: logger}, nil
+}
+
+func (f *FileStorageClient) PostRide(athlete, ride string, data io.Reader) error {
+	return f.putObject(data, repository.RidesBucketName, athlete, ride)
+}
+
+func (f *FileStorageClient) PostMapData(athlete, ride string, data io.Reader) error {
+	return f.putObject(data, repository.MapDataBucketName, athlete, ride)
+}
+
+func (f *FileStorageClient) PostAthlete(athlete string, data io.Reader) error {
+	return f.putObject(data, repository.AthletesBucketName, athlete)
+}
+
+func (f *FileStorageClient) PostSyncState(athlete string, data io.Reader) error {
+	return f.putObject(data, repository.SyncBucketName, athlete)
+}
+
+func (f *FileStorageClient) PostToken(athlete string, data io.Reader) error {
+	return f.putObject(data, repository.TokensBucketName, athlete)
+}
+
+// RemoveRide removes the ride and its map data
+func (f *FileStorageClient) RemoveRide(athlete, ride string) error {
+	if err := f.removeObject(repository.RidesBucketName, athlete, ride); err != nil {
+		return err
+	}
+	return f.removeObject(repository.MapDataBucketName, athlete, ride)
+}
+
+// RemoveAthlete removes the athlete's profile, token, sync state, rides and
+// map data
+func (f *FileStorageClient) RemoveAthlete(athlete string) error {
+	for _, bucket := range []string{repository.RidesBucketName, repository.MapDataBucketName} {
+		dir, err := f.path(bucket, athlete)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("could not remove objects: %v", err)
+		}
+	}
+	for _, bucket := range []string{repository.AthletesBucketName, repository.SyncBucketName, repository.TokensBucketName} {
+		if err := f.removeObject(bucket, athlete); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileStorageClient) GetRide(out io.Writer, athlete, ride string) (bool, error) {
+	return f.writeObject(out, repository.RidesBucketName, athlete, ride)
+}
+
+func (f *FileStorageClient) HasRide(athlete, ride string) (bool, error) {
+	file, err := f.objectPath(repository.RidesBucketName, athlete, ride)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(file)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not stat object: %v", err)
+	}
+	return true, nil
+}
+
+func (f *FileStorageClient) GetAthlete(out io.Writer, athlete string) (bool, error) {
+	return f.writeObject(out, repository.AthletesBucketName, athlete)
+}
+
+func (f *FileStorageClient) GetSyncState(out io.Writer, athlete string) (bool, error) {
+	return f.writeObject(out, repository.SyncBucketName, athlete)
+}
+
+func (f *FileStorageClient) GetToken(out io.Writer, athlete string) (bool, error) {
+	return f.writeObject(out, repository.TokensBucketName, athlete)
+}
+
+func (f *FileStorageClient) GetAllMapPlaces(athlete string) (*model.SpotList, error) {
+	places := model.SpotList{}
+	places.Data = make([]model.Spot, 0)
+
+	dir, err := f.path(repository.MapDataBucketName, athlete)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return &places, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list map data: %v", err)
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), fileExtension) {
+			continue
+		}
+		data, err := os.Open(filepath.Join(dir, file.Name()))
+		if err != nil {
+			f.logger.Printf("could not get object from repo: %v", err)
+			continue
+		}
+		sl, err := model.NewSpotListFromJSON(data)
+		data.Close()
+		if err != nil {
+			f.logger.Printf("could not parse object: %v", err)
+			continue
+		}
+
+		places.Data = append(places.Data, sl.Data...)
+	}
+
+	return &places, nil
+}
+
+// path returns the directory of the keys, or the file of the object when the
+// last key is the object's name. Keys must not escape the bucket.
+func (f *FileStorageClient) path(bucket string, keys ...string) (string, error) {
+	parts := []string{f.root, bucket}
+	for _, key := range keys {
+		if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+			return "", fmt.Errorf("invalid key '%s'", key)
+		}
+		parts = append(parts, key)
+	}
+	return filepath.Join(parts...), nil
+}
+
+func (f *FileStorageClient) objectPath(bucket string, keys ...string) (string, error) {
+	p, err := f.path(bucket, keys...)
+	if err != nil {
+		return "", err
+	}
+	return p + fileExtension, nil
+}
+
+// putObject writes to a temporary file which is renamed over the object, so
+// readers never see a partially written object
+func (f *FileStorageClient) putObject(data io.Reader, bucket string, keys ...string) error {
+	file, err := f.objectPath(bucket, keys...)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("could not create bucket directory: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write object: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write object: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write object: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("could not store object: %v", err)
+	}
+	return nil
+}
+
+func (f *FileStorageClient) writeObject(out io.Writer, bucket string, keys ...string) (bool, error) {
+	file, err := f.objectPath(bucket, keys...)
+	if err != nil {
+		return false, err
+	}
+	data, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not open object: %v", err)
+	}
+	defer data.Close()
+
+	if _, err = io.Copy(out, data); err != nil {
+		return true, fmt.Errorf("could not read object: %v", err)
+	}
+	return true, nil
+}
+
+func (f *FileStorageClient) removeObject(bucket string, keys ...string) error {
+	file, err := f.objectPath(bucket, keys...)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove object: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/IcoBoyanov/lazy-spots/repository"
+	"github.com/IcoBoyanov/lazy-spots/repository/repotest"
+)
+
+func TestRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		repo, err := New(log.New(ioutil.Discard, "", 0), t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
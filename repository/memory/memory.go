@@ -0,0 +1,167 @@
+// Package memory keeps the repository in memory, for tests and ephemeral runs
+// where nothing has to survive a restart
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/IcoBoyanov/lazy-spots/repository"
+)
+
+// MemoryStorageClient is safe for concurrent use
+type MemoryStorageClient struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+	logger  *log.Logger
+}
+
+func New(logger *log.Logger) repository.Repository {
+	return &MemoryStorageClient{
+		buckets: make(map[string]map[string][]byte),
+		logger:  logger,
+	}
+}
+
+// rideKey namespaces a ride's objects by the athlete
+func rideKey(athlete, ride string) string {
+	return athlete + "/" + ride
+}
+
+func (m *MemoryStorageClient) PostRide(athlete, ride string, data io.Reader) error {
+	return m.putObject(repository.RidesBucketName, rideKey(athlete, ride), data)
+}
+
+func (m *MemoryStorageClient) PostMapData(athlete, ride string, data io.Reader) error {
+	return m.putObject(repository.MapDataBucketName, rideKey(athlete, ride), data)
+}
+
+func (m *MemoryStorageClient) PostAthlete(athlete string, data io.Reader) error {
+	return m.putObject(repository.AthletesBucketName, athlete, data)
+}
+
+func (m *MemoryStorageClient) PostSyncState(athlete string, data io.Reader) error {
+	return m.putObject(repository.SyncBucketName, athlete, data)
+}
+
+func (m *MemoryStorageClient) PostToken(athlete string, data io.Reader) error {
+	return m.putObject(repository.TokensBucketName, athlete, data)
+}
+
+// RemoveRide removes the ride and its map data
+func (m *MemoryStorageClient) RemoveRide(athlete, ride string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.buckets[repository.RidesBucketName], rideKey(athlete, ride))
+	delete(m.buckets[repository.MapDataBucketName], rideKey(athlete, ride))
+	return nil
+}
+
+// RemoveAthlete removes the athlete's profile, token, sync state, rides and
+// map data
+func (m *MemoryStorageClient) RemoveAthlete(athlete string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, bucket := range []string{repository.RidesBucketName, repository.MapDataBucketName} {
+		for key := range m.buckets[bucket] {
+			if strings.HasPrefix(key, rideKey(athlete, "")) {
+				delete(m.buckets[bucket], key)
+			}
+		}
+	}
+	for _, bucket := range []string{repository.AthletesBucketName, repository.SyncBucketName, repository.TokensBucketName} {
+		delete(m.buckets[bucket], athlete)
+	}
+	return nil
+}
+
+func (m *MemoryStorageClient) GetRide(out io.Writer, athlete, ride string) (bool, error) {
+	return m.writeObject(out, repository.RidesBucketName, rideKey(athlete, ride))
+}
+
+func (m *MemoryStorageClient) HasRide(athlete, ride string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.buckets[repository.RidesBucketName][rideKey(athlete, ride)]
+	return ok, nil
+}
+
+func (m *MemoryStorageClient) GetAthlete(out io.Writer, athlete string) (bool, error) {
+	return m.writeObject(out, repository.AthletesBucketName, athlete)
+}
+
+func (m *MemoryStorageClient) GetSyncState(out io.Writer, athlete string) (bool, error) {
+	return m.writeObject(out, repository.SyncBucketName, athlete)
+}
+
+func (m *MemoryStorageClient) GetToken(out io.Writer, athlete string) (bool, error) {
+	return m.writeObject(out, repository.TokensBucketName, athlete)
+}
+
+func (m *MemoryStorageClient) GetAllMapPlaces(athlete string) (*model.SpotList, error) {
+	places := model.SpotList{}
+	places.Data = make([]model.Spot, 0)
+
+	m.mu.RLock()
+	prefix := rideKey(athlete, "")
+	var keys []string
+	for key := range m.buckets[repository.MapDataBucketName] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	objects := make([][]byte, len(keys))
+	for i, key := range keys {
+		objects[i] = m.buckets[repository.MapDataBucketName][key]
+	}
+	m.mu.RUnlock()
+
+	for _, object := range objects {
+		sl, err := model.NewSpotListFromJSON(bytes.NewReader(object))
+		if err != nil {
+			m.logger.Printf("could not parse object: %v", err)
+			continue
+		}
+		places.Data = append(places.Data, sl.Data...)
+	}
+
+	return &places, nil
+}
+
+func (m *MemoryStorageClient) putObject(bucket, key string, data io.Reader) error {
+	content, err := ioutil.ReadAll(data)
+	if err != nil {
+		return fmt.Errorf("could not read object: %v", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.buckets[bucket] == nil {
+		m.buckets[bucket] = make(map[string][]byte)
+	}
+	m.buckets[bucket][key] = content
+	return nil
+}
+
+func (m *MemoryStorageClient) writeObject(out io.Writer, bucket, key string) (bool, error) {
+	m.mu.RLock()
+	content, ok := m.buckets[bucket][key]
+	m.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+
+	// stored objects are never modified in place, so they can be read unlocked
+	if _, err := out.Write(content); err != nil {
+		return true, fmt.Errorf("could not read object: %v", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,16 @@
+package memory
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/IcoBoyanov/lazy-spots/repository"
+	"github.com/IcoBoyanov/lazy-spots/repository/repotest"
+)
+
+func TestRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		return New(log.New(ioutil.Discard, "", 0))
+	})
+}
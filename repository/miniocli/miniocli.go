@@ -5,127 +5,238 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"strings"
 
 	"github.com/IcoBoyanov/lazy-spots/model"
 	"github.com/IcoBoyanov/lazy-spots/repository"
 	"github.com/minio/minio-go/v7"
 )
 
-const RidesBucketName = "rides"
-const AthletesBucketName = "athletes"
-const MapDataBucketName = "maps"
+// Buckets are the names of the buckets the repository is stored in
+type Buckets struct {
+	Rides    string `json:"rides"`
+	Athletes string `json:"athletes"`
+	MapData  string `json:"maps"`
+	Sync     string `json:"sync"`
+	Tokens   string `json:"tokens"`
+}
 
-var (
-	minioClient *minio.Client
-	logger      *log.Logger
-)
+var DefaultBuckets = Buckets{
+	Rides:    repository.RidesBucketName,
+	Athletes: repository.AthletesBucketName,
+	MapData:  repository.MapDataBucketName,
+	Sync:     repository.SyncBucketName,
+	Tokens:   repository.TokensBucketName,
+}
 
-type MinioStorageClient struct{}
+func (b Buckets) all() []string {
+	return []string{b.Rides, b.Athletes, b.MapData, b.Sync, b.Tokens}
+}
 
-func New(logger *log.Logger, client *minio.Client) repository.Repository {
-	minioClient = client
-	logger.Printf("%#v\n", minioClient) // minioClient is now setup
-	return &MinioStorageClient{}
+// MinioStorageClient stores the repository in minio. Several clients can be
+// used at the same time, e.g. with different servers or buckets.
+type MinioStorageClient struct {
+	client  *minio.Client
+	buckets Buckets
+	logger  *log.Logger
+	ctx     context.Context
 }
 
-func (m *MinioStorageClient) PostRide(ride string, data io.Reader) error {
-	// Create a bucket at region 'us-east-1' with object locking enabled.
-	err := minioClient.MakeBucket(context.Background(), RidesBucketName, minio.MakeBucketOptions{})
-	if err == nil {
-		fmt.Printf("Successfully created bucket '%s'.\n", RidesBucketName)
+// New creates the missing buckets. All requests are made with ctx.
+func New(ctx context.Context, logger *log.Logger, client *minio.Client, buckets Buckets) (*MinioStorageClient, error) {
+	m := &MinioStorageClient{
+		client:  client,
+		buckets: buckets,
+		logger:  logger,
+		ctx:     ctx,
 	}
-	if err != nil {
-		if err.(minio.ErrorResponse).StatusCode != 409 {
-			fmt.Println(err)
-			return err
+	for _, bucket := range buckets.all() {
+		if err := m.makeBucket(bucket); err != nil {
+			return nil, err
 		}
 	}
+	logger.Printf("storing data in %s", client.EndpointURL())
 
-	uploadInfo, err := minioClient.PutObject(context.Background(), RidesBucketName, ride, data, -1, minio.PutObjectOptions{ContentType: "application/json"})
+	legacy, err := m.legacyRides()
 	if err != nil {
-		fmt.Println(err)
-		return err
+		return nil, err
+	}
+	if legacy > 0 {
+		logger.Printf("%d rides are stored without an athlete and are not shown, move them to their athlete with 'lazy-spots migrate <athlete>'", legacy)
 	}
-	fmt.Println("Successfully uploaded bytes: ", uploadInfo)
+	return m, nil
+}
+
+func (m *MinioStorageClient) makeBucket(bucket string) error {
+	exists, err := m.client.BucketExists(m.ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("could not check bucket '%s': %v", bucket, err)
+	}
+	if exists {
+		return nil
+	}
+	err = m.client.MakeBucket(m.ctx, bucket, minio.MakeBucketOptions{})
+	if err != nil && minio.ToErrorResponse(err).Code != "BucketAlreadyOwnedByYou" {
+		return fmt.Errorf("could not create bucket '%s': %v", bucket, err)
+	}
+	m.logger.Printf("created bucket '%s'", bucket)
 	return nil
 }
 
-func (m *MinioStorageClient) PostMapData(ride string, data io.Reader) error {
-	// Create a bucket at region 'us-east-1' with object locking enabled.
-	err := minioClient.MakeBucket(context.Background(), MapDataBucketName, minio.MakeBucketOptions{})
-	if err == nil {
-		fmt.Printf("Successfully created bucket '%s'.\n", MapDataBucketName)
+// rideKey namespaces a ride's objects by the athlete
+func rideKey(athlete, ride string) string {
+	return athlete + "/" + ride
+}
+
+// legacyObjects lists the objects of bucket which were stored before rides
+// were namespaced by the athlete, their keys are just the ride
+func (m *MinioStorageClient) legacyObjects(bucket string) ([]string, error) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+	var keys []string
+	for o := range m.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{}) {
+		if o.Err != nil {
+			return nil, fmt.Errorf("could not list objects from minio: %v", o.Err)
+		}
+		if !strings.Contains(o.Key, "/") {
+			keys = append(keys, o.Key)
+		}
 	}
-	if err != nil {
-		if err.(minio.ErrorResponse).StatusCode != 409 {
-			fmt.Println(err)
-			return err
+	return keys, nil
+}
+
+func (m *MinioStorageClient) legacyRides() (int, error) {
+	legacy := 0
+	for _, bucket := range []string{m.buckets.Rides, m.buckets.MapData} {
+		keys, err := m.legacyObjects(bucket)
+		if err != nil {
+			return 0, err
 		}
+		legacy += len(keys)
 	}
+	return legacy, nil
+}
 
-	uploadInfo, err := minioClient.PutObject(context.Background(), MapDataBucketName, ride, data, -1, minio.PutObjectOptions{ContentType: "application/json"})
-	if err != nil {
-		fmt.Println(err)
-		return err
+// MigrateRides moves the rides and stops stored without an athlete to the
+// athlete and returns how many objects were moved. Such rides were stored
+// when an instance had a single athlete.
+func (m *MinioStorageClient) MigrateRides(athlete string) (int, error) {
+	moved := 0
+	for _, bucket := range []string{m.buckets.Rides, m.buckets.MapData} {
+		keys, err := m.legacyObjects(bucket)
+		if err != nil {
+			return moved, err
+		}
+		for _, key := range keys {
+			dst := minio.CopyDestOptions{Bucket: bucket, Object: rideKey(athlete, key)}
+			src := minio.CopySrcOptions{Bucket: bucket, Object: key}
+			if _, err := m.client.CopyObject(m.ctx, dst, src); err != nil {
+				return moved, fmt.Errorf("could not copy object '%s' in minio: %v", key, err)
+			}
+			if err := m.removeObject(bucket, key); err != nil {
+				return moved, err
+			}
+			moved++
+		}
 	}
-	fmt.Println("Successfully uploaded bytes: ", uploadInfo)
-	return nil
+	return moved, nil
+}
+
+func (m *MinioStorageClient) PostRide(athlete, ride string, data io.Reader) error {
+	return m.putObject(m.buckets.Rides, rideKey(athlete, ride), data)
+}
+
+func (m *MinioStorageClient) PostMapData(athlete, ride string, data io.Reader) error {
+	return m.putObject(m.buckets.MapData, rideKey(athlete, ride), data)
 }
 
 func (m *MinioStorageClient) PostAthlete(athlete string, data io.Reader) error {
-	// Create a bucket at region 'us-east-1' with object locking enabled.
-	err := minioClient.MakeBucket(context.Background(), AthletesBucketName, minio.MakeBucketOptions{})
-	if err == nil {
-		fmt.Printf("Successfully created bucket '%s'.\n", AthletesBucketName)
-	}
-	if err != nil {
-		if err.(minio.ErrorResponse).StatusCode != 409 {
-			fmt.Println(err)
+	return m.putObject(m.buckets.Athletes, athlete, data)
+}
+
+func (m *MinioStorageClient) PostSyncState(athlete string, data io.Reader) error {
+	return m.putObject(m.buckets.Sync, athlete, data)
+}
+
+func (m *MinioStorageClient) PostToken(athlete string, data io.Reader) error {
+	return m.putObject(m.buckets.Tokens, athlete, data)
+}
+
+// RemoveRide removes the ride and its map data
+func (m *MinioStorageClient) RemoveRide(athlete, ride string) error {
+	for _, bucket := range []string{m.buckets.Rides, m.buckets.MapData} {
+		if err := m.removeObject(bucket, rideKey(athlete, ride)); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	uploadInfo, err := minioClient.PutObject(context.Background(), AthletesBucketName, athlete, data, -1, minio.PutObjectOptions{ContentType: "application/json"})
-	if err != nil {
-		fmt.Println(err)
-		return err
+// RemoveAthlete removes the athlete's profile, token, sync state, rides and
+// map data
+func (m *MinioStorageClient) RemoveAthlete(athlete string) error {
+	for _, bucket := range []string{m.buckets.Rides, m.buckets.MapData} {
+		if err := m.removePrefix(bucket, rideKey(athlete, "")); err != nil {
+			return err
+		}
+	}
+	for _, bucket := range []string{m.buckets.Athletes, m.buckets.Sync, m.buckets.Tokens} {
+		if err := m.removeObject(bucket, athlete); err != nil {
+			return err
+		}
 	}
-	fmt.Println("Successfully uploaded bytes: ", uploadInfo)
 	return nil
 }
 
-func (m *MinioStorageClient) RemoveRide(ride string) error    { return nil }
-func (m *MinioStorageClient) RemoveAthlete(ride string) error { return nil }
+func (m *MinioStorageClient) GetRide(out io.Writer, athlete, ride string) (bool, error) {
+	return m.getObject(out, m.buckets.Rides, rideKey(athlete, ride))
+}
 
-func (m *MinioStorageClient) GetRide(out io.Writer, ride string) (bool, error) {
-	_, err := minioClient.StatObject(context.Background(), RidesBucketName, ride, minio.GetObjectOptions{})
+// HasRide only stats the object, it is not downloaded
+func (m *MinioStorageClient) HasRide(athlete, ride string) (bool, error) {
+	_, err := m.client.StatObject(m.ctx, m.buckets.Rides, rideKey(athlete, ride), minio.StatObjectOptions{})
+	if isNotFound(err) {
+		return false, nil
+	}
 	if err != nil {
-		errResponse := minio.ToErrorResponse(err)
-		if errResponse.Code == "NoSuchKey" {
-			return false, nil
-		}
 		return false, fmt.Errorf("could not stat object from minio: %v", err)
 	}
-	return true, m.writeObject(out, RidesBucketName, ride)
+	return true, nil
+}
+
+func (m *MinioStorageClient) GetAthlete(out io.Writer, athlete string) (bool, error) {
+	return m.getObject(out, m.buckets.Athletes, athlete)
+}
+
+func (m *MinioStorageClient) GetSyncState(out io.Writer, athlete string) (bool, error) {
+	return m.getObject(out, m.buckets.Sync, athlete)
+}
+
+func (m *MinioStorageClient) GetToken(out io.Writer, athlete string) (bool, error) {
+	return m.getObject(out, m.buckets.Tokens, athlete)
 }
 
-func (m *MinioStorageClient) GetAllMapPlaces() (*model.SpotList, error) {
+func (m *MinioStorageClient) GetAllMapPlaces(athlete string) (*model.SpotList, error) {
 	places := model.SpotList{}
 	places.Data = make([]model.Spot, 0)
-	objects := minioClient.ListObjects(context.Background(), MapDataBucketName, minio.ListObjectsOptions{})
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+	objects := m.client.ListObjects(ctx, m.buckets.MapData, minio.ListObjectsOptions{Prefix: rideKey(athlete, ""), Recursive: true})
 	for o := range objects {
-		if o.Key == "" {
-			continue
+		if o.Err != nil {
+			return nil, fmt.Errorf("could not list map data: %v", o.Err)
 		}
-		data, err := m.getObject(MapDataBucketName, o.Key)
+		data, err := m.client.GetObject(m.ctx, m.buckets.MapData, o.Key, minio.GetObjectOptions{})
 		if err != nil {
-			fmt.Printf("could not get object from repo: %v", err)
+			m.logger.Printf("could not get object from repo: %v", err)
 			continue
 		}
 
 		sl, err := model.NewSpotListFromJSON(data)
+		data.Close()
 		if err != nil {
-			fmt.Printf("could not parse object: %v", err)
+			m.logger.Printf("could not parse object: %v", err)
 			continue
 		}
 
@@ -135,37 +246,61 @@ func (m *MinioStorageClient) GetAllMapPlaces() (*model.SpotList, error) {
 	return &places, nil
 }
 
-func (m *MinioStorageClient) GetAthlete(out io.Writer, athlete string) (bool, error) {
-	_, err := minioClient.StatObject(context.Background(), AthletesBucketName, athlete, minio.GetObjectOptions{})
+func (m *MinioStorageClient) putObject(bucket, object string, data io.Reader) error {
+	_, err := m.client.PutObject(m.ctx, bucket, object, data, -1, minio.PutObjectOptions{ContentType: "application/json"})
+	if err != nil {
+		return fmt.Errorf("could not put object to minio: %v", err)
+	}
+	return nil
+}
+
+// getObject copies the object to out, a missing object is not an error
+func (m *MinioStorageClient) getObject(out io.Writer, bucket, object string) (bool, error) {
+	_, err := m.client.StatObject(m.ctx, bucket, object, minio.StatObjectOptions{})
 	if err != nil {
-		errResponse := minio.ToErrorResponse(err)
-		if errResponse.Code == "NoSuchKey" {
+		if isNotFound(err) {
 			return false, nil
 		}
 		return false, fmt.Errorf("could not stat object from minio: %v", err)
 	}
-	return true, m.writeObject(out, AthletesBucketName, athlete)
-}
 
-func (m *MinioStorageClient) writeObject(out io.Writer, bucket, object string) error {
-	data, err := minioClient.GetObject(context.Background(), bucket, object, minio.GetObjectOptions{})
+	data, err := m.client.GetObject(m.ctx, bucket, object, minio.GetObjectOptions{})
 	if err != nil {
-		fmt.Println(err)
-		return err
+		return true, fmt.Errorf("could not get object from minio: %v", err)
 	}
-
+	defer data.Close()
 	if _, err = io.Copy(out, data); err != nil {
-		fmt.Println(err)
-		return err
+		return true, fmt.Errorf("could not read object from minio: %v", err)
+	}
+	return true, nil
+}
+
+// removeObject removes the object, missing objects are not an error
+func (m *MinioStorageClient) removeObject(bucket, object string) error {
+	err := m.client.RemoveObject(m.ctx, bucket, object, minio.RemoveObjectOptions{})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("could not remove object from minio: %v", err)
 	}
 	return nil
 }
 
-func (m *MinioStorageClient) getObject(bucket, object string) (*minio.Object, error) {
-	data, err := minioClient.GetObject(context.Background(), bucket, object, minio.GetObjectOptions{})
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
+func (m *MinioStorageClient) removePrefix(bucket, prefix string) error {
+	ctx, cancel := context.WithCancel(m.ctx)
+	defer cancel()
+	objects := m.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	for o := range objects {
+		if o.Err != nil {
+			return fmt.Errorf("could not list objects from minio: %v", o.Err)
+		}
+		if err := m.removeObject(bucket, o.Key); err != nil {
+			return err
+		}
 	}
-	return data, nil
+	return nil
+}
+
+// isNotFound reports whether the object or its bucket does not exist
+func isNotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NoSuchBucket"
 }
@@ -0,0 +1,113 @@
+package miniocli
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/repository"
+	"github.com/IcoBoyanov/lazy-spots/repository/repotest"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// The suite runs against the minio server at MINIO_TEST_ENDPOINT, every
+// subtest creates its own buckets and removes them afterwards
+const (
+	testEndpointEnv  = "MINIO_TEST_ENDPOINT"
+	testAccessKeyEnv = "MINIO_TEST_ACCESS_KEY"
+	testSecretEnv    = "MINIO_TEST_SECRET"
+)
+
+var testBuckets int64
+
+// testClient connects to the server at MINIO_TEST_ENDPOINT and skips the
+// test without it
+func testClient(t *testing.T) *minio.Client {
+	endpoint := os.Getenv(testEndpointEnv)
+	if endpoint == "" {
+		t.Skipf("%s is not set", testEndpointEnv)
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewStaticV4(os.Getenv(testAccessKeyEnv), os.Getenv(testSecretEnv), ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// newTestRepository stores the repository in new buckets which are removed
+// after the test
+func newTestRepository(t *testing.T, client *minio.Client) *MinioStorageClient {
+	prefix := fmt.Sprintf("lazy-spots-test-%d-%d", time.Now().Unix(), atomic.AddInt64(&testBuckets, 1))
+	buckets := Buckets{
+		Rides:    prefix + "-rides",
+		Athletes: prefix + "-athletes",
+		MapData:  prefix + "-maps",
+		Sync:     prefix + "-sync",
+		Tokens:   prefix + "-tokens",
+	}
+	ctx := context.Background()
+	repo, err := New(ctx, log.New(ioutil.Discard, "", 0), client, buckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, bucket := range buckets.all() {
+			repo.removePrefix(bucket, "")
+			client.RemoveBucket(ctx, bucket)
+		}
+	})
+	return repo
+}
+
+func TestRepository(t *testing.T) {
+	client := testClient(t)
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		return newTestRepository(t, client)
+	})
+}
+
+func TestMigrateRides(t *testing.T) {
+	repo := newTestRepository(t, testClient(t))
+	// Rides and stops as they were stored before rides had an athlete
+	if err := repo.putObject(repo.buckets.Rides, "42", strings.NewReader(`{"id":42}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.putObject(repo.buckets.MapData, "42", strings.NewReader(`{"data":[{"lat":42.69,"lng":23.32,"activity":"42"}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.PostRide("7", "43", strings.NewReader(`{"id":43}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if legacy, err := repo.legacyRides(); err != nil || legacy != 2 {
+		t.Fatalf("legacyRides() = %d, %v, want 2", legacy, err)
+	}
+	moved, err := repo.MigrateRides("7")
+	if err != nil || moved != 2 {
+		t.Fatalf("MigrateRides() = %d, %v, want 2", moved, err)
+	}
+	if legacy, err := repo.legacyRides(); err != nil || legacy != 0 {
+		t.Fatalf("legacyRides() after migrating = %d, %v, want 0", legacy, err)
+	}
+
+	var ride strings.Builder
+	if ok, err := repo.GetRide(&ride, "7", "42"); err != nil || !ok || ride.String() != `{"id":42}` {
+		t.Errorf("GetRide() = %v, %v with %q", ok, err, ride.String())
+	}
+	places, err := repo.GetAllMapPlaces("7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(places.Data) != 1 {
+		t.Errorf("got %d places of the athlete, want 1", len(places.Data))
+	}
+}
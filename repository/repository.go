@@ -1,18 +1,60 @@
 package repository
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/IcoBoyanov/lazy-spots/model"
 )
 
+// Names of the buckets, directories or tables every backend stores its data in
+const (
+	RidesBucketName    = "rides"
+	AthletesBucketName = "athletes"
+	MapDataBucketName  = "maps"
+	SyncBucketName     = "sync"
+	TokensBucketName   = "tokens"
+)
+
+// Repository stores the data of several athletes. Rides and map data are
+// namespaced by the athlete they belong to.
 type Repository interface {
-	PostRide(ride string, data io.Reader) error
-	PostMapData(ride string, data io.Reader) error
-	GetAllMapPlaces() (*model.SpotList, error)
+	PostRide(athlete, ride string, data io.Reader) error
+	PostMapData(athlete, ride string, data io.Reader) error
+	GetAllMapPlaces(athlete string) (*model.SpotList, error)
 	PostAthlete(athlete string, data io.Reader) error
-	RemoveRide(ride string) error
+	RemoveRide(athlete, ride string) error
 	RemoveAthlete(athlete string) error
-	GetRide(io.Writer, string) (bool, error)
+	GetRide(out io.Writer, athlete, ride string) (bool, error)
+	HasRide(athlete, ride string) (bool, error)
 	GetAthlete(io.Writer, string) (bool, error)
+	PostSyncState(athlete string, data io.Reader) error
+	GetSyncState(io.Writer, string) (bool, error)
+	PostToken(athlete string, data io.Reader) error
+	GetToken(io.Writer, string) (bool, error)
+}
+
+// RideStore is implemented by backends that can store a ride together with
+// its map data atomically, either both are stored or neither is
+type RideStore interface {
+	PostRideWithMapData(athlete, ride string, data, mapData io.Reader) error
+}
+
+// StoreRide stores the ride's streams and the spots found in it, atomically
+// when repo is a RideStore
+func StoreRide(repo Repository, athlete, ride string, stream *model.ActivityStream, spots *model.SpotList) error {
+	if store, ok := repo.(RideStore); ok {
+		if err := store.PostRideWithMapData(athlete, ride, stream.Reader(), spots.Reader()); err != nil {
+			return fmt.Errorf("could not store activity: %v", err)
+		}
+		return nil
+	}
+
+	if err := repo.PostRide(athlete, ride, stream.Reader()); err != nil {
+		return fmt.Errorf("could not store activity: %v", err)
+	}
+	if err := repo.PostMapData(athlete, ride, spots.Reader()); err != nil {
+		return fmt.Errorf("could not store activity places: %v", err)
+	}
+	return nil
 }
@@ -0,0 +1,344 @@
+// Package repotest is a conformance suite for repository.Repository
+// implementations. A backend's tests call Run with a constructor returning an
+// empty repository:
+//
+//	func TestRepository(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) repository.Repository {
+//			return memory.New(log.New(ioutil.Discard, "", 0))
+//		})
+//	}
+package repotest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/IcoBoyanov/lazy-spots/repository"
+)
+
+// NewRepository returns an empty repository for a single subtest
+type NewRepository func(t *testing.T) repository.Repository
+
+// Run runs every conformance check as a subtest of t
+func Run(t *testing.T, newRepo NewRepository) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, repository.Repository)
+	}{
+		{"PutGet", testPutGet},
+		{"Overwrite", testOverwrite},
+		{"MissingKeys", testMissingKeys},
+		{"AthleteNamespaces", testAthleteNamespaces},
+		{"HasRide", testHasRide},
+		{"RemoveRide", testRemoveRide},
+		{"RemoveAthlete", testRemoveAthlete},
+		{"RemoveMissing", testRemoveMissing},
+		{"MapPlaces", testMapPlaces},
+		{"MapPlacesSkipsInvalid", testMapPlacesSkipsInvalid},
+		{"ConcurrentWrites", testConcurrentWrites},
+		{"RideStore", testRideStore},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newRepo(t))
+		})
+	}
+}
+
+type getter func(out io.Writer) (bool, error)
+
+func mustGet(t *testing.T, what string, get getter) string {
+	t.Helper()
+	var out bytes.Buffer
+	found, err := get(&out)
+	if err != nil {
+		t.Fatalf("get %s: %v", what, err)
+	}
+	if !found {
+		t.Fatalf("get %s: not found", what)
+	}
+	return out.String()
+}
+
+func mustMiss(t *testing.T, what string, get getter) {
+	t.Helper()
+	var out bytes.Buffer
+	found, err := get(&out)
+	if err != nil {
+		t.Fatalf("get %s: %v", what, err)
+	}
+	if found {
+		t.Fatalf("get %s: found %q, want not found", what, out.String())
+	}
+}
+
+func must(t *testing.T, what string, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+}
+
+func ride(athlete, ride string, r repository.Repository) getter {
+	return func(out io.Writer) (bool, error) { return r.GetRide(out, athlete, ride) }
+}
+
+func spots(lats ...float64) io.Reader {
+	sl := model.SpotList{Data: make([]model.Spot, 0, len(lats))}
+	for _, lat := range lats {
+		sl.Data = append(sl.Data, model.Spot{Lat: lat, Lng: lat, Duration: 120})
+	}
+	return sl.Reader()
+}
+
+func lats(t *testing.T, r repository.Repository, athlete string) []float64 {
+	t.Helper()
+	places, err := r.GetAllMapPlaces(athlete)
+	must(t, "get map places", err)
+	if places == nil || places.Data == nil {
+		t.Fatalf("get map places: got nil, want an empty list")
+	}
+	result := make([]float64, 0, len(places.Data))
+	for _, s := range places.Data {
+		result = append(result, s.Lat)
+	}
+	sort.Float64s(result)
+	return result
+}
+
+func testPutGet(t *testing.T, r repository.Repository) {
+	must(t, "post ride", r.PostRide("1", "10", strings.NewReader(`{"ride":10}`)))
+	must(t, "post athlete", r.PostAthlete("1", strings.NewReader(`{"athlete":1}`)))
+	must(t, "post sync state", r.PostSyncState("1", strings.NewReader(`{"sync":1}`)))
+	must(t, "post token", r.PostToken("1", strings.NewReader(`{"token":1}`)))
+
+	checks := []struct {
+		what string
+		get  getter
+		want string
+	}{
+		{"ride", ride("1", "10", r), `{"ride":10}`},
+		{"athlete", func(out io.Writer) (bool, error) { return r.GetAthlete(out, "1") }, `{"athlete":1}`},
+		{"sync state", func(out io.Writer) (bool, error) { return r.GetSyncState(out, "1") }, `{"sync":1}`},
+		{"token", func(out io.Writer) (bool, error) { return r.GetToken(out, "1") }, `{"token":1}`},
+	}
+	for _, c := range checks {
+		if got := mustGet(t, c.what, c.get); got != c.want {
+			t.Errorf("get %s = %q, want %q", c.what, got, c.want)
+		}
+	}
+}
+
+func testOverwrite(t *testing.T, r repository.Repository) {
+	must(t, "post ride", r.PostRide("1", "10", strings.NewReader(`{"version":1}`)))
+	must(t, "post ride", r.PostRide("1", "10", strings.NewReader(`{"version":2}`)))
+	if got := mustGet(t, "ride", ride("1", "10", r)); got != `{"version":2}` {
+		t.Errorf("get ride = %q, want the second version", got)
+	}
+}
+
+func testMissingKeys(t *testing.T, r repository.Repository) {
+	mustMiss(t, "ride", ride("1", "10", r))
+	mustMiss(t, "athlete", func(out io.Writer) (bool, error) { return r.GetAthlete(out, "1") })
+	mustMiss(t, "sync state", func(out io.Writer) (bool, error) { return r.GetSyncState(out, "1") })
+	mustMiss(t, "token", func(out io.Writer) (bool, error) { return r.GetToken(out, "1") })
+	if got := lats(t, r, "1"); len(got) != 0 {
+		t.Errorf("map places of an unknown athlete = %v, want none", got)
+	}
+
+	// a missing key next to existing ones
+	must(t, "post ride", r.PostRide("1", "10", strings.NewReader(`{}`)))
+	mustMiss(t, "ride", ride("1", "11", r))
+}
+
+func testAthleteNamespaces(t *testing.T, r repository.Repository) {
+	must(t, "post ride", r.PostRide("1", "10", strings.NewReader(`{"athlete":1}`)))
+	must(t, "post ride", r.PostRide("2", "10", strings.NewReader(`{"athlete":2}`)))
+	must(t, "post map data", r.PostMapData("1", "10", spots(1)))
+	must(t, "post map data", r.PostMapData("2", "10", spots(2, 2)))
+	// an athlete whose id is a prefix of another's
+	must(t, "post map data", r.PostMapData("11", "10", spots(11)))
+
+	if got := mustGet(t, "ride", ride("1", "10", r)); got != `{"athlete":1}` {
+		t.Errorf("get ride of athlete 1 = %q", got)
+	}
+	if got := mustGet(t, "ride", ride("2", "10", r)); got != `{"athlete":2}` {
+		t.Errorf("get ride of athlete 2 = %q", got)
+	}
+	if got := lats(t, r, "1"); fmt.Sprint(got) != "[1]" {
+		t.Errorf("map places of athlete 1 = %v, want [1]", got)
+	}
+	if got := lats(t, r, "2"); fmt.Sprint(got) != "[2 2]" {
+		t.Errorf("map places of athlete 2 = %v, want [2 2]", got)
+	}
+}
+
+func testHasRide(t *testing.T, r repository.Repository) {
+	must(t, "post ride", r.PostRide("1", "10", strings.NewReader(`{}`)))
+	must(t, "post map data", r.PostMapData("1", "11", spots(11)))
+
+	tests := []struct {
+		athlete, ride string
+		want          bool
+	}{
+		{"1", "10", true},
+		{"1", "11", false}, // only its map data is stored
+		{"1", "12", false},
+		{"2", "10", false},
+		{"11", "0", false},
+	}
+	for _, tt := range tests {
+		got, err := r.HasRide(tt.athlete, tt.ride)
+		must(t, "has ride", err)
+		if got != tt.want {
+			t.Errorf("has ride %s of athlete %s = %v, want %v", tt.ride, tt.athlete, got, tt.want)
+		}
+	}
+
+	must(t, "remove ride", r.RemoveRide("1", "10"))
+	if got, err := r.HasRide("1", "10"); err != nil || got {
+		t.Errorf("has removed ride = %v, %v, want false", got, err)
+	}
+}
+
+func testRemoveRide(t *testing.T, r repository.Repository) {
+	must(t, "post ride", r.PostRide("1", "10", strings.NewReader(`{}`)))
+	must(t, "post ride", r.PostRide("1", "11", strings.NewReader(`{}`)))
+	must(t, "post map data", r.PostMapData("1", "10", spots(10)))
+	must(t, "post map data", r.PostMapData("1", "11", spots(11)))
+	must(t, "remove ride", r.RemoveRide("1", "10"))
+
+	mustMiss(t, "removed ride", ride("1", "10", r))
+	mustGet(t, "other ride", ride("1", "11", r))
+	if got := lats(t, r, "1"); fmt.Sprint(got) != "[11]" {
+		t.Errorf("map places = %v, want only the ones of the other ride", got)
+	}
+}
+
+func testRemoveAthlete(t *testing.T, r repository.Repository) {
+	for _, athlete := range []string{"1", "2"} {
+		must(t, "post athlete", r.PostAthlete(athlete, strings.NewReader(`{}`)))
+		must(t, "post sync state", r.PostSyncState(athlete, strings.NewReader(`{}`)))
+		must(t, "post token", r.PostToken(athlete, strings.NewReader(`{}`)))
+		must(t, "post ride", r.PostRide(athlete, "10", strings.NewReader(`{}`)))
+		must(t, "post ride", r.PostRide(athlete, "11", strings.NewReader(`{}`)))
+		must(t, "post map data", r.PostMapData(athlete, "10", spots(10)))
+		must(t, "post map data", r.PostMapData(athlete, "11", spots(11)))
+	}
+	must(t, "remove athlete", r.RemoveAthlete("1"))
+
+	mustMiss(t, "removed athlete", func(out io.Writer) (bool, error) { return r.GetAthlete(out, "1") })
+	mustMiss(t, "sync state of removed athlete", func(out io.Writer) (bool, error) { return r.GetSyncState(out, "1") })
+	mustMiss(t, "token of removed athlete", func(out io.Writer) (bool, error) { return r.GetToken(out, "1") })
+	mustMiss(t, "ride of removed athlete", ride("1", "10", r))
+	mustMiss(t, "ride of removed athlete", ride("1", "11", r))
+	if got := lats(t, r, "1"); len(got) != 0 {
+		t.Errorf("map places of removed athlete = %v, want none", got)
+	}
+
+	mustGet(t, "other athlete", func(out io.Writer) (bool, error) { return r.GetAthlete(out, "2") })
+	mustGet(t, "token of other athlete", func(out io.Writer) (bool, error) { return r.GetToken(out, "2") })
+	mustGet(t, "ride of other athlete", ride("2", "10", r))
+	if got := lats(t, r, "2"); fmt.Sprint(got) != "[10 11]" {
+		t.Errorf("map places of other athlete = %v, want [10 11]", got)
+	}
+}
+
+func testRemoveMissing(t *testing.T, r repository.Repository) {
+	must(t, "remove missing ride", r.RemoveRide("1", "10"))
+	must(t, "remove missing athlete", r.RemoveAthlete("1"))
+}
+
+func testMapPlaces(t *testing.T, r repository.Repository) {
+	const rides = 25
+	var want []float64
+	for i := 0; i < rides; i++ {
+		must(t, "post map data", r.PostMapData("1", fmt.Sprint(i), spots(float64(i), float64(i))))
+		want = append(want, float64(i), float64(i))
+	}
+	// an activity without stops adds nothing
+	must(t, "post map data", r.PostMapData("1", "empty", spots()))
+
+	if got := lats(t, r, "1"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("map places = %v, want %v", got, want)
+	}
+}
+
+func testMapPlacesSkipsInvalid(t *testing.T, r repository.Repository) {
+	must(t, "post map data", r.PostMapData("1", "10", spots(1)))
+	must(t, "post map data", r.PostMapData("1", "11", strings.NewReader("not json")))
+	if got := lats(t, r, "1"); fmt.Sprint(got) != "[1]" {
+		t.Errorf("map places = %v, want [1]", got)
+	}
+}
+
+func testConcurrentWrites(t *testing.T, r repository.Repository) {
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*2)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprint(i)
+			errs <- r.PostRide("1", id, strings.NewReader(id))
+			errs <- r.PostMapData("1", id, spots(float64(i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		must(t, "concurrent post", err)
+	}
+
+	for i := 0; i < writers; i++ {
+		id := fmt.Sprint(i)
+		if got := mustGet(t, "ride", ride("1", id, r)); got != id {
+			t.Errorf("get ride %s = %q", id, got)
+		}
+	}
+	if got := lats(t, r, "1"); len(got) != writers {
+		t.Errorf("map places = %v, want %d", got, writers)
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, fmt.Errorf("read failed") }
+
+// testRideStore checks that a ride and its map data are stored together, it is
+// skipped for backends which are not a repository.RideStore
+func testRideStore(t *testing.T, r repository.Repository) {
+	store, ok := r.(repository.RideStore)
+	if !ok {
+		t.Skip("not a RideStore")
+	}
+
+	must(t, "post ride with map data", store.PostRideWithMapData("1", "10", strings.NewReader(`{"ride":10}`), spots(1, 2)))
+	if got := mustGet(t, "ride", ride("1", "10", r)); got != `{"ride":10}` {
+		t.Errorf("get ride = %q, want %q", got, `{"ride":10}`)
+	}
+	if got := lats(t, r, "1"); fmt.Sprint(got) != "[1 2]" {
+		t.Errorf("map places = %v, want [1 2]", got)
+	}
+
+	// Neither object is stored when one of them can not be read
+	if err := store.PostRideWithMapData("1", "11", strings.NewReader(`{"ride":11}`), errReader{}); err == nil {
+		t.Errorf("post ride with failing map data: expected an error")
+	}
+	if err := store.PostRideWithMapData("1", "12", errReader{}, spots(3)); err == nil {
+		t.Errorf("post failing ride with map data: expected an error")
+	}
+	mustMiss(t, "ride with failing map data", ride("1", "11", r))
+	mustMiss(t, "failing ride", ride("1", "12", r))
+	if got := lats(t, r, "1"); fmt.Sprint(got) != "[1 2]" {
+		t.Errorf("map places = %v, want [1 2]", got)
+	}
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/IcoBoyanov/lazy-spots/repository"
+	"github.com/IcoBoyanov/lazy-spots/strava"
+	"github.com/julienschmidt/httprouter"
+)
+
+// StartCollection starts collecting the athlete's activities in the background
+// and responds with the job. Activities started since the last sync are
+// collected and the ones already in the repository are skipped. With
+// "?full=true" every activity is fetched and its spots are extracted again.
+func (rh *RequestServer) StartCollection(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	client, ok := rh.requireSession(w, req)
+	if !ok {
+		return
+	}
+	full := req.URL.Query().Get("full") == "true"
+
+	job, err := rh.jobs.Start(client.AthleteID(), full, func(ctx context.Context, job *Job) error {
+		return rh.collect(ctx, client, job)
+	})
+	if err == ErrJobRunning {
+		running, _ := rh.jobs.Running(client.AthleteID())
+		w.Header().Set("Location", "/jobs/"+running.ID())
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID())
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job.Status())
+}
+
+func (rh *RequestServer) GetJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	job, ok := rh.athleteJob(w, req, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job.Status())
+}
+
+// CancelJob stops a running job, activities collected so far are kept
+func (rh *RequestServer) CancelJob(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	job, ok := rh.athleteJob(w, req, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	rh.jobs.Cancel(job.ID())
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// athleteJob returns the job if it belongs to the logged in athlete
+func (rh *RequestServer) athleteJob(w http.ResponseWriter, req *http.Request, id string) (*Job, bool) {
+	client, ok := rh.requireSession(w, req)
+	if !ok {
+		return nil, false
+	}
+	job, ok := rh.jobs.Get(id)
+	if !ok || job.Athlete() != client.AthleteID() {
+		http.Error(w, fmt.Sprintf("job '%s' not found", id), http.StatusNotFound)
+		return nil, false
+	}
+	return job, true
+}
+
+// collect stores the athlete's activities and the spots found in them
+func (rh *RequestServer) collect(ctx context.Context, client strava.StravaClient, job *Job) error {
+	athleteID := client.AthleteID()
+
+	if ok, err := rh.repo.GetAthlete(ioutil.Discard, athleteID); err != nil || !ok {
+		if _, err := rh.fetchAthlete(ctx, client); err != nil {
+			return fmt.Errorf("could not fetch athlete: %v", err)
+		}
+	}
+
+	state := &model.SyncState{Athlete: athleteID}
+	if !job.Full() {
+		var buf bytes.Buffer
+		ok, err := rh.repo.GetSyncState(&buf, athleteID)
+		if err != nil {
+			return fmt.Errorf("could not load last sync: %v", err)
+		}
+		if ok {
+			if state, err = model.NewSyncState(&buf); err != nil {
+				return fmt.Errorf("could not load last sync: %v", err)
+			}
+		}
+	}
+
+	sl, err := client.GetActivitySumamryList(ctx, time.Time{}, state.LastActivity)
+	if err != nil {
+		return fmt.Errorf("could not list activities: %v", err)
+	}
+	sl = rh.regions.Filter(sl)
+	sort.Slice(sl.SumamryList, func(i, j int) bool {
+		return sl.SumamryList[i].StartDate.Before(sl.SumamryList[j].StartDate)
+	})
+	job.setTotal(len(sl.SumamryList))
+
+	// The high-water mark only moves past activities stored without errors so
+	// that failed ones are retried on the next sync
+	collected := rh.collectAll(ctx, client, job, sl.SumamryList)
+	for i, ok := range collected {
+		if !ok {
+			break
+		}
+		state.LastActivity = sl.SumamryList[i].StartDate
+	}
+
+	state.LastSync = time.Now()
+	if err := rh.repo.PostSyncState(athleteID, state.Reader()); err != nil {
+		return fmt.Errorf("could not store sync state: %v", err)
+	}
+	return nil
+}
+
+type fetchedActivity struct {
+	index  int
+	stream *model.ActivityStream
+	err    error
+}
+
+// collectAll fetches the activities' streams with a pool of workers and feeds
+// them to storage and spot extraction as they arrive. It reports which
+// activities are in the repository afterwards.
+func (rh *RequestServer) collectAll(ctx context.Context, client strava.StravaClient, job *Job, activities []model.ActivitySummary) []bool {
+	collected := make([]bool, len(activities))
+	pending := make(chan int)
+	fetched := make(chan fetchedActivity)
+
+	go func() {
+		defer close(pending)
+		for i, sum := range activities {
+			activityID := strconv.Itoa(sum.ID)
+			if !job.Full() {
+				if ok, err := rh.repo.HasRide(client.AthleteID(), activityID); err == nil && ok {
+					job.skip()
+					collected[i] = true
+					continue
+				}
+			}
+			select {
+			case pending <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < rh.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pending {
+				activityID := strconv.Itoa(activities[i].ID)
+				job.emit(Event{Type: EventActivityStarted, Activity: activityID})
+				stream, err := rh.fetchActivity(ctx, client, job, activityID)
+				fetched <- fetchedActivity{index: i, stream: stream, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(fetched)
+	}()
+
+	for f := range fetched {
+		sum := activities[f.index]
+		activityID := strconv.Itoa(sum.ID)
+		if f.err != nil {
+			if ctx.Err() == nil {
+				job.fail(activityID, f.err)
+			}
+			continue
+		}
+		spots, err := rh.storeActivity(client.AthleteID(), job, activityID, sum.StartDate, f.stream)
+		if err != nil {
+			job.fail(activityID, err)
+			continue
+		}
+		collected[f.index] = true
+		job.done(activityID, spots.Data)
+	}
+	return collected
+}
+
+// collectActivity stores the activity streams and the spots found in them.
+// Progress is reported to the job, which may be nil.
+func (rh *RequestServer) collectActivity(ctx context.Context, client strava.StravaClient, job *Job, activityID string, start time.Time) (*model.SpotList, error) {
+	stream, err := rh.fetchActivity(ctx, client, job, activityID)
+	if err != nil {
+		return nil, err
+	}
+	return rh.storeActivity(client.AthleteID(), job, activityID, start, stream)
+}
+
+func (rh *RequestServer) fetchActivity(ctx context.Context, client strava.StravaClient, job *Job, activityID string) (*model.ActivityStream, error) {
+	ctx = strava.WithRateLimitNotify(ctx, func(until time.Time) {
+		job.emit(Event{Type: EventRateLimited, Activity: activityID, Until: &until})
+	})
+	stream, err := client.GetRide(ctx, activityID)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch activity: %v", err)
+	}
+	return stream, nil
+}
+
+func (rh *RequestServer) storeActivity(athlete string, job *Job, activityID string, start time.Time, stream *model.ActivityStream) (*model.SpotList, error) {
+	sl := rh.detector.SpotList(stream)
+	sl.SetActivity(activityID, start)
+
+	if err := repository.StoreRide(rh.repo, athlete, activityID, stream, sl); err != nil {
+		return nil, err
+	}
+	job.emit(Event{Type: EventActivityStored, Activity: activityID})
+	return sl, nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/IcoBoyanov/lazy-spots/repository/memory"
+	"github.com/IcoBoyanov/lazy-spots/strava"
+)
+
+const (
+	benchActivities = 40
+	benchLatency    = 20 * time.Millisecond
+)
+
+// fakeStrava serves an athlete with benchActivities activities, every
+// stream request takes benchLatency like a round trip to Strava
+func fakeStrava(b *testing.B) *httptest.Server {
+	streams, err := json.Marshal(testStream(120).Streams)
+	if err != nil {
+		b.Fatal(err)
+	}
+	activities := make([]model.ActivitySummary, benchActivities)
+	for i := range activities {
+		activities[i] = model.ActivitySummary{ID: i + 1, StartDate: time.Date(2020, 1, 1+i, 8, 0, 0, 0, time.UTC)}
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.URL.Path == "/athlete":
+			fmt.Fprint(w, `{"id":1}`)
+		case req.URL.Path == "/athlete/activities":
+			if req.URL.Query().Get("page") == "1" {
+				json.NewEncoder(w).Encode(activities)
+				return
+			}
+			fmt.Fprint(w, `[]`)
+		case strings.HasSuffix(req.URL.Path, "/streams"):
+			time.Sleep(benchLatency)
+			w.Write(streams)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+}
+
+func benchmarkCollect(b *testing.B, workers int) {
+	api := fakeStrava(b)
+	defer api.Close()
+
+	repo := memory.New(log.New(ioutil.Discard, "", 0))
+	token := model.Token{Athlete: "1", AccessToken: "access", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}
+	if err := repo.PostToken("1", token.Reader()); err != nil {
+		b.Fatal(err)
+	}
+	service, err := strava.NewStravaService("http://localhost/callback", "id", "secret", repo,
+		strava.WithEndpoint(api.URL+"/"), strava.WithRateLimiter(strava.NewRateLimiter(api.Client().Transport)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	client, err := service.Client("1")
+	if err != nil {
+		b.Fatal(err)
+	}
+	rh := NewRequestServer(repo, service, []byte("key"))
+	rh.SetCollectWorkers(workers)
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		job, err := rh.jobs.Start("1", true, func(ctx context.Context, job *Job) error {
+			return rh.collect(ctx, client, job)
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		<-job.Stopped()
+		if status := job.Status(); status.State != JobCompleted || status.Done != benchActivities {
+			b.Fatalf("job %s with %d of %d activities: %v", status.State, status.Done, benchActivities, status.Errors)
+		}
+	}
+	b.ReportMetric(float64(b.N*benchActivities)/time.Since(start).Seconds(), "activities/s")
+}
+
+// The gain of more workers grows with Strava's latency, which the fake API
+// simulates with benchLatency per stream request
+func BenchmarkCollect(b *testing.B) {
+	for _, workers := range []int{1, model.DefaultCollectWorkers, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			benchmarkCollect(b, workers)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Types of the events emitted while collecting
+const (
+	EventActivityStarted = "activity_started"
+	EventActivityStored  = "activity_stored"
+	EventSpotsExtracted  = "spots_extracted"
+	EventActivityFailed  = "activity_failed"
+	EventRateLimited     = "rate_limited"
+	EventFinished        = "finished"
+)
+
+// eventBuffer is how many events a slow subscriber may fall behind before
+// events are dropped for it
+const eventBuffer = 64
+
+// Event reports the progress of a collection job
+type Event struct {
+	Type     string       `json:"type"`
+	Job      string       `json:"job"`
+	Activity string       `json:"activity,omitempty"`
+	Spots    []model.Spot `json:"spots,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Until    *time.Time   `json:"until,omitempty"`
+	State    JobState     `json:"state"`
+	Total    int          `json:"total"`
+	Done     int          `json:"done"`
+}
+
+// subscribe returns a channel of the job's events which is closed once the
+// job has finished, and a function to stop receiving them
+func (j *Job) subscribe() (<-chan Event, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	ch := make(chan Event, eventBuffer)
+	if j.status.State != JobRunning {
+		ch <- j.event(EventFinished)
+		close(ch)
+		return ch, func() {}
+	}
+	j.subscribers[ch] = struct{}{}
+	return ch, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if _, ok := j.subscribers[ch]; ok {
+			delete(j.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// emit sends an event to all subscribers, a nil job discards it
+func (j *Job) emit(e Event) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.broadcast(e)
+}
+
+// broadcast must be called with j.mu held
+func (j *Job) broadcast(e Event) {
+	event := j.event(e.Type)
+	event.Activity, event.Spots, event.Error, event.Until = e.Activity, e.Spots, e.Error, e.Until
+	for ch := range j.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// event must be called with j.mu held
+func (j *Job) event(eventType string) Event {
+	return Event{
+		Type:  eventType,
+		Job:   j.status.ID,
+		State: j.status.State,
+		Total: j.status.Total,
+		Done:  j.status.Done,
+	}
+}
+
+// closeSubscribers must be called with j.mu held
+func (j *Job) closeSubscribers() {
+	for ch := range j.subscribers {
+		close(ch)
+		delete(j.subscribers, ch)
+	}
+}
+
+// CollectionEvents streams the events of the athlete's collection job as
+// Server-Sent Events. The job is given with "?job=<id>" and defaults to the
+// running one.
+func (rh *RequestServer) CollectionEvents(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	client, ok := rh.requireSession(w, req)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var job *Job
+	if id := req.URL.Query().Get("job"); id != "" {
+		job, ok = rh.jobs.Get(id)
+		ok = ok && job.Athlete() == client.AthleteID()
+	} else {
+		job, ok = rh.jobs.Running(client.AthleteID())
+	}
+	if !ok {
+		http.Error(w, "no collection job found", http.StatusNotFound)
+		return
+	}
+
+	events, unsubscribe := job.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Media types of the places and spots responses
+const (
+	JSONContentType    = "application/json"
+	GeoJSONContentType = "application/geo+json"
+	GPXContentType     = "application/gpx+xml"
+	KMLContentType     = "application/vnd.google-earth.kml+xml"
+)
+
+type format struct {
+	contentType string
+	extension   string
+	write       func(exporter, io.Writer) error
+}
+
+var formats = []format{
+	{JSONContentType, "", exporter.Write},
+	{GeoJSONContentType, ".geojson", exporter.WriteGeoJSON},
+	{GPXContentType, ".gpx", exporter.WriteGPX},
+	{KMLContentType, ".kml", exporter.WriteKML},
+}
+
+// exporter is implemented by model.SpotList and model.ClusterList
+type exporter interface {
+	Write(io.Writer) error
+	WriteGeoJSON(io.Writer) error
+	WriteGPX(io.Writer) error
+	WriteKML(io.Writer) error
+}
+
+// writeFormat writes data in the format selected by the extension of the
+// path, e.g. /spots.gpx, or else by the Accept header. JSON is the default.
+// GPX and KML are sent as file downloads named after name. A request which
+// accepts none of the formats is answered with 406.
+func writeFormat(w http.ResponseWriter, req *http.Request, name string, data exporter) {
+	w.Header().Add("Vary", "Accept")
+	f, ok := negotiate(req)
+	if !ok {
+		types := make([]string, 0, len(formats))
+		for _, f := range formats {
+			types = append(types, f.contentType)
+		}
+		http.Error(w, fmt.Sprintf("acceptable types are %s", strings.Join(types, ", ")), http.StatusNotAcceptable)
+		return
+	}
+	w.Header().Set("Content-Type", f.contentType)
+	if f.contentType == GPXContentType || f.contentType == KMLContentType {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, name, f.extension))
+	}
+	f.write(data, w)
+}
+
+// negotiate returns the format of the path's extension, the extension names
+// the format explicitly and wins over the Accept header. Otherwise the format
+// with the highest quality in the Accept header is chosen, ties go to the
+// first of formats. Without an Accept header the format is JSON.
+func negotiate(req *http.Request) (format, bool) {
+	if ext := path.Ext(req.URL.Path); ext != "" {
+		for _, f := range formats {
+			if f.extension == ext {
+				return f, true
+			}
+		}
+	}
+
+	header := strings.TrimSpace(req.Header.Get("Accept"))
+	if header == "" {
+		return formats[0], true
+	}
+	ranges := parseAccept(header)
+	best, bestQ := formats[0], 0.0
+	for _, f := range formats {
+		if q := quality(ranges, f.contentType); q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// mediaRange is a type of the Accept header, e.g. application/* with its
+// quality
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(header string) []mediaRange {
+	var ranges []mediaRange
+	for _, accept := range strings.Split(header, ",") {
+		params := strings.Split(accept, ";")
+		r := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if r.mediaType == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil && q >= 0 && q <= 1 {
+					r.q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges
+}
+
+// quality is the quality of the most specific range matching contentType,
+// type/subtype before type/* before */*. 0 is not acceptable.
+func quality(ranges []mediaRange, contentType string) float64 {
+	mainType := contentType[:strings.Index(contentType, "/")]
+	q, specificity := 0.0, -1
+	for _, r := range ranges {
+		s := -1
+		switch r.mediaType {
+		case contentType:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q
+}
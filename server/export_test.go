@@ -0,0 +1,203 @@
+package server
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		accept string
+		want   string
+	}{
+		{"no accept header", "/spots", "", JSONContentType},
+		{"json", "/spots", "application/json", JSONContentType},
+		{"geojson", "/spots", "application/geo+json", GeoJSONContentType},
+		{"gpx", "/spots", "application/gpx+xml", GPXContentType},
+		{"kml", "/spots", "application/vnd.google-earth.kml+xml", KMLContentType},
+		{"case insensitive", "/spots", "Application/GPX+XML", GPXContentType},
+		{"unknown types are skipped", "/spots", "text/csv, application/gpx+xml", GPXContentType},
+		{"higher quality wins", "/spots", "application/json;q=0.5, application/vnd.google-earth.kml+xml", KMLContentType},
+		{"higher quality wins regardless of order", "/spots", "application/gpx+xml;q=0.2, application/geo+json;q=0.9", GeoJSONContentType},
+		{"ties go to the first format", "/spots", "application/gpx+xml, application/geo+json", GeoJSONContentType},
+		{"q=0 is not acceptable", "/spots", "application/geo+json;q=0, application/gpx+xml;q=0.1", GPXContentType},
+		{"q=0.0 is not acceptable", "/spots", "application/json; q=0.0, application/geo+json;q=0.5", GeoJSONContentType},
+		{"browser", "/spots", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", JSONContentType},
+		{"any type", "/spots", "*/*", JSONContentType},
+		{"any application type", "/spots", "application/*", JSONContentType},
+		{"specific type over wildcard", "/spots", "application/*;q=0.5, application/kml+xml, application/gpx+xml;q=0.8", GPXContentType},
+		{"excluded type under a wildcard", "/spots", "application/json;q=0, */*", GeoJSONContentType},
+		{"invalid quality is ignored", "/spots", "application/gpx+xml;q=abc", GPXContentType},
+		{"extension", "/spots.gpx", "", GPXContentType},
+		{"extension over accept", "/spots.kml", "application/gpx+xml", KMLContentType},
+		{"extension over an excluding accept", "/places.geojson", "application/geo+json;q=0", GeoJSONContentType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			f, ok := negotiate(req)
+			if !ok {
+				t.Fatalf("no acceptable format, want %s", tt.want)
+			}
+			if f.contentType != tt.want {
+				t.Errorf("format = %s, want %s", f.contentType, tt.want)
+			}
+		})
+	}
+}
+
+func testSpots() *model.SpotList {
+	at := time.Date(2021, 3, 4, 8, 10, 0, 0, time.UTC)
+	return &model.SpotList{Data: []model.Spot{
+		{Lat: 42.69, Lng: 23.32, Start: 600, Duration: 300, Activity: "4711", Time: &at},
+		{Lat: 42.7, Lng: 23.33, Start: 60, Duration: 120, Activity: "4712"},
+	}}
+}
+
+func TestWriteFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		accept      string
+		status      int
+		contentType string
+		disposition string
+		// check decodes the body
+		check func(t *testing.T, body string)
+	}{
+		{
+			name:        "json",
+			path:        "/places",
+			status:      http.StatusOK,
+			contentType: JSONContentType,
+			check: func(t *testing.T, body string) {
+				var sl model.SpotList
+				if err := json.Unmarshal([]byte(body), &sl); err != nil || len(sl.Data) != 2 {
+					t.Errorf("json of %d spots: %v", len(sl.Data), err)
+				}
+			},
+		},
+		{
+			name:        "geojson",
+			path:        "/places",
+			accept:      GeoJSONContentType,
+			status:      http.StatusOK,
+			contentType: GeoJSONContentType,
+			check: func(t *testing.T, body string) {
+				var fc struct {
+					Type     string `json:"type"`
+					Features []struct {
+						Geometry struct {
+							Coordinates [2]float64 `json:"coordinates"`
+						} `json:"geometry"`
+					} `json:"features"`
+				}
+				if err := json.Unmarshal([]byte(body), &fc); err != nil || fc.Type != "FeatureCollection" || len(fc.Features) != 2 {
+					t.Fatalf("geojson %s: %v", body, err)
+				}
+				if fc.Features[0].Geometry.Coordinates != [2]float64{23.32, 42.69} {
+					t.Errorf("first feature at %v, want [23.32 42.69]", fc.Features[0].Geometry.Coordinates)
+				}
+			},
+		},
+		{
+			name:        "gpx download",
+			path:        "/places.gpx",
+			status:      http.StatusOK,
+			contentType: GPXContentType,
+			disposition: `attachment; filename="places.gpx"`,
+			check: func(t *testing.T, body string) {
+				var doc struct {
+					XMLName   xml.Name
+					Waypoints []struct {
+						Lat float64 `xml:"lat,attr"`
+						Lon float64 `xml:"lon,attr"`
+					} `xml:"wpt"`
+				}
+				if err := xml.Unmarshal([]byte(body), &doc); err != nil || doc.XMLName.Local != "gpx" || len(doc.Waypoints) != 2 {
+					t.Fatalf("gpx %s: %v", body, err)
+				}
+				if doc.Waypoints[0].Lat != 42.69 || doc.Waypoints[0].Lon != 23.32 {
+					t.Errorf("first waypoint at %v", doc.Waypoints[0])
+				}
+			},
+		},
+		{
+			name:        "kml download",
+			path:        "/places",
+			accept:      "application/json;q=0.1, " + KMLContentType,
+			status:      http.StatusOK,
+			contentType: KMLContentType,
+			disposition: `attachment; filename="places.kml"`,
+			check: func(t *testing.T, body string) {
+				var doc struct {
+					XMLName    xml.Name
+					Placemarks []struct {
+						Coordinates string `xml:"Point>coordinates"`
+					} `xml:"Document>Placemark"`
+				}
+				if err := xml.Unmarshal([]byte(body), &doc); err != nil || doc.XMLName.Local != "kml" || len(doc.Placemarks) != 2 {
+					t.Fatalf("kml %s: %v", body, err)
+				}
+				if doc.Placemarks[0].Coordinates != "23.32,42.69" {
+					t.Errorf("first placemark at %q, want lng,lat", doc.Placemarks[0].Coordinates)
+				}
+			},
+		},
+		{
+			name:   "no acceptable format",
+			path:   "/places",
+			accept: "text/html, application/xml",
+			status: http.StatusNotAcceptable,
+			check: func(t *testing.T, body string) {
+				if !strings.Contains(body, GPXContentType) {
+					t.Errorf("406 body %q does not list the acceptable types", body)
+				}
+			},
+		},
+		{
+			name:   "every format excluded",
+			path:   "/places",
+			accept: "*/*;q=0",
+			status: http.StatusNotAcceptable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			writeFormat(w, req, "places", testSpots())
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.contentType != "" && w.Header().Get("Content-Type") != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", w.Header().Get("Content-Type"), tt.contentType)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tt.disposition {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.disposition)
+			}
+			if w.Header().Get("Vary") != "Accept" {
+				t.Errorf("Vary = %q, want Accept", w.Header().Get("Vary"))
+			}
+			if tt.check != nil {
+				tt.check(t, w.Body.String())
+			}
+		})
+	}
+}
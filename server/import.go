@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/importer"
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MaxImportSize limits the size of an upload to ImportRides
+const MaxImportSize = 32 << 20
+
+// ImportedRide describes a ride stored by ImportRides
+type ImportedRide struct {
+	ID    string       `json:"id"`
+	File  string       `json:"file"`
+	Start time.Time    `json:"start"`
+	Spots []model.Spot `json:"spots"`
+}
+
+// ImportRides stores the GPX, TCX or FIT files uploaded as the "file" fields
+// of a multipart form. Nothing is stored unless every file can be read.
+// Imported rides are not filtered by region.
+func (rh *RequestServer) ImportRides(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	client, ok := rh.requireSession(w, req)
+	if !ok {
+		return
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, MaxImportSize)
+	if err := req.ParseMultipartForm(MaxImportSize); err != nil {
+		http.Error(w, fmt.Sprintf("could not read upload: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer req.MultipartForm.RemoveAll()
+	files := req.MultipartForm.File["file"]
+	if len(files) == 0 {
+		http.Error(w, "no file uploaded, expected a 'file' field", http.StatusBadRequest)
+		return
+	}
+
+	activities := make([]*importer.Activity, len(files))
+	for i, fh := range files {
+		format, err := importer.FormatOf(fh.Filename)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", fh.Filename, err), http.StatusBadRequest)
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", fh.Filename, err), http.StatusBadRequest)
+			return
+		}
+		activities[i], err = importer.Import(f, format)
+		f.Close()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", fh.Filename, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	rides := make([]ImportedRide, 0, len(activities))
+	for i, a := range activities {
+		sl, err := a.Store(rh.repo, client.AthleteID(), rh.detector)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", files[i].Filename, err), http.StatusInternalServerError)
+			return
+		}
+		rides = append(rides, ImportedRide{ID: a.ID, File: files[i].Filename, Start: a.Start, Spots: sl.Data})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rides)
+}
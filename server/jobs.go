@@ -0,0 +1,232 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+)
+
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobCompleted JobState = "completed"
+	JobFailed    JobState = "failed"
+	JobCancelled JobState = "cancelled"
+)
+
+// JobRetention is how long finished jobs can still be queried
+const JobRetention = 24 * time.Hour
+
+var ErrJobRunning = errors.New("a collection is already running for this athlete")
+
+// JobStatus is the progress of a collection job
+type JobStatus struct {
+	ID       string    `json:"id"`
+	Athlete  string    `json:"athlete"`
+	Full     bool      `json:"full"`
+	State    JobState  `json:"state"`
+	Total    int       `json:"total"`
+	Done     int       `json:"done"`
+	Skipped  int       `json:"skipped"`
+	Failed   []string  `json:"failed"`
+	Errors   []string  `json:"errors"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// Job is a collection running in the background
+type Job struct {
+	mu          sync.Mutex
+	status      JobStatus
+	cancel      context.CancelFunc
+	stopped     chan struct{}
+	subscribers map[chan Event]struct{}
+}
+
+// Status returns a snapshot of the job's progress
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	status.Failed = append([]string{}, j.status.Failed...)
+	status.Errors = append([]string{}, j.status.Errors...)
+	return status
+}
+
+func (j *Job) ID() string {
+	return j.status.ID
+}
+
+func (j *Job) Athlete() string {
+	return j.status.Athlete
+}
+
+func (j *Job) Full() bool {
+	return j.status.Full
+}
+
+// Stopped is closed once the job has finished
+func (j *Job) Stopped() <-chan struct{} {
+	return j.stopped
+}
+
+func (j *Job) setTotal(total int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Total = total
+}
+
+// done records a collected activity and the spots found in it
+func (j *Job) done(activityID string, spots []model.Spot) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Done++
+	j.broadcast(Event{Type: EventSpotsExtracted, Activity: activityID, Spots: spots})
+}
+
+func (j *Job) skip() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Done++
+	j.status.Skipped++
+}
+
+// fail records an error, activityID is empty for errors outside of an activity
+func (j *Job) fail(activityID string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if activityID != "" {
+		j.status.Done++
+		j.status.Failed = append(j.status.Failed, activityID)
+		j.status.Errors = append(j.status.Errors, fmt.Sprintf("activity '%s': %v", activityID, err))
+		j.broadcast(Event{Type: EventActivityFailed, Activity: activityID, Error: err.Error()})
+		return
+	}
+	j.status.Errors = append(j.status.Errors, err.Error())
+}
+
+func (j *Job) finish(ctx context.Context, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Finished = time.Now()
+	switch {
+	case ctx.Err() == context.Canceled:
+		j.status.State = JobCancelled
+	case err != nil:
+		j.status.State = JobFailed
+		j.status.Errors = append(j.status.Errors, err.Error())
+	default:
+		j.status.State = JobCompleted
+	}
+	j.broadcast(Event{Type: EventFinished})
+	j.closeSubscribers()
+}
+
+// JobManager runs at most one collection job per athlete
+type JobManager struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	running map[string]*Job
+}
+
+func NewJobManager() *JobManager {
+	return &JobManager{
+		jobs:    make(map[string]*Job),
+		running: make(map[string]*Job),
+	}
+}
+
+// Start runs the job in the background. It fails with ErrJobRunning while
+// another job of the athlete is running.
+func (m *JobManager) Start(athlete string, full bool, run func(context.Context, *Job) error) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.running[athlete]; ok {
+		return nil, ErrJobRunning
+	}
+	m.prune(time.Now())
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		status: JobStatus{
+			ID:      id,
+			Athlete: athlete,
+			Full:    full,
+			State:   JobRunning,
+			Failed:  make([]string, 0),
+			Errors:  make([]string, 0),
+			Started: time.Now(),
+		},
+		cancel:      cancel,
+		stopped:     make(chan struct{}),
+		subscribers: make(map[chan Event]struct{}),
+	}
+	m.jobs[id] = job
+	m.running[athlete] = job
+
+	go func() {
+		err := run(ctx, job)
+		job.finish(ctx, err)
+		cancel()
+
+		m.mu.Lock()
+		delete(m.running, athlete)
+		m.mu.Unlock()
+		close(job.stopped)
+	}()
+	return job, nil
+}
+
+func (m *JobManager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	return job, ok
+}
+
+// Running returns the athlete's running job
+func (m *JobManager) Running(athlete string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.running[athlete]
+	return job, ok
+}
+
+// Cancel stops the job, the job state changes once it has stopped
+func (m *JobManager) Cancel(id string) bool {
+	job, ok := m.Get(id)
+	if !ok {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// prune forgets jobs which finished more than JobRetention ago
+func (m *JobManager) prune(now time.Time) {
+	for id, job := range m.jobs {
+		status := job.Status()
+		if status.State != JobRunning && now.Sub(status.Finished) > JobRetention {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not create job id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,11 +1,15 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"html"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/IcoBoyanov/lazy-spots/geo"
 	"github.com/IcoBoyanov/lazy-spots/model"
 	"github.com/IcoBoyanov/lazy-spots/repository"
 	"github.com/IcoBoyanov/lazy-spots/strava"
@@ -23,99 +27,300 @@ const HomeRoute = "/"
 // }
 
 type RequestServer struct {
-	strava strava.StravaService
-	repo   repository.Repository
+	strava  strava.StravaService
+	repo    repository.Repository
+	signer  signer
+	jobs    *JobManager
+	workers int
+	regions *model.RegionFilter
 
-	athleteID string
+	detector      model.StopDetector
+	clusterRadius float64
+	spotsLimit    int
+
+	webhookToken string
+	subscription int64
+	webhooks     chan WebhookEvent
 	// logger        *log.Logger
 }
 
-func NewRequestServer(repo repository.Repository, strava strava.StravaService) *RequestServer {
-	return &RequestServer{
-		strava: strava,
-		repo:   repo,
+// NewRequestServer creates the server, sessionKey signs the session cookies
+func NewRequestServer(repo repository.Repository, strava strava.StravaService, sessionKey []byte) *RequestServer {
+	rh := &RequestServer{
+		strava:   strava,
+		repo:     repo,
+		signer:   signer{key: sessionKey},
+		jobs:     NewJobManager(),
+		workers:  model.DefaultCollectWorkers,
+		webhooks: make(chan WebhookEvent, WebhookQueueSize),
+
+		detector:      model.StopDetector{MinDuration: model.DefaultMinStopDuration},
+		clusterRadius: model.DefaultClusterRadius,
+		spotsLimit:    model.DefaultSpotsLimit,
+	}
+	go rh.processWebhooks()
+	return rh
+}
+
+// SetRegionFilter limits collection to activities in the regions, a nil
+// filter collects everything
+func (rh *RequestServer) SetRegionFilter(regions *model.RegionFilter) {
+	rh.regions = regions
+}
+
+// SetStopDetector sets how stops are detected in collected activities
+func (rh *RequestServer) SetStopDetector(detector model.StopDetector) {
+	rh.detector = detector
+}
+
+// SetSpotsDefaults sets the clustering radius in meters and the number of
+// clusters of /spots when the request does not set them
+func (rh *RequestServer) SetSpotsDefaults(radius float64, limit int) {
+	rh.clusterRadius = radius
+	rh.spotsLimit = limit
+}
+
+// SetCollectWorkers sets how many activities are fetched concurrently
+func (rh *RequestServer) SetCollectWorkers(n int) {
+	if n > 0 {
+		rh.workers = n
 	}
 }
 
-func (rh *RequestServer) Authenticated() bool {
-	return rh.strava.IsTokenValid()
+// Authenticated reports whether an athlete is logged in the browser
+func (rh *RequestServer) Authenticated(req *http.Request) bool {
+	_, err := rh.session(req)
+	return err == nil
+}
+
+// requireSession returns the logged in athlete's client or responds with 401
+func (rh *RequestServer) requireSession(w http.ResponseWriter, req *http.Request) (strava.StravaClient, bool) {
+	client, err := rh.session(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("please login first: %v", err), http.StatusUnauthorized)
+		return nil, false
+	}
+	return client, true
 }
 
 // func (rh *RequestServer) Client() *http.Client { return rh.strava.Client() }
 
 func (rh *RequestServer) Login(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	authURL := rh.strava.GetAuthURL()
+	state, err := rh.newState(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	authURL := rh.strava.GetAuthURL(state)
 	http.Redirect(w, req, authURL, http.StatusTemporaryRedirect)
 }
 
 func (rh *RequestServer) Callback(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	err := rh.strava.Authenticate(req.Context(), req.URL)
+	if err := rh.consumeState(w, req); err != nil {
+		loginFailed(w, http.StatusForbidden, err)
+		return
+	}
+	client, err := rh.strava.Authenticate(req.Context(), req.URL)
 	if err != nil {
+		loginFailed(w, http.StatusBadRequest, err)
 		return
 	}
+	rh.startSession(w, client.AthleteID())
+	http.Redirect(w, req, HomeRoute, http.StatusTemporaryRedirect)
+}
+
+func loginFailed(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<html><body><p>Login failed: %s</p><a href="/login">Try again</a></body></html>`, html.EscapeString(err.Error()))
+}
+
+func (rh *RequestServer) Logout(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	rh.endSession(w)
 	http.Redirect(w, req, HomeRoute, http.StatusTemporaryRedirect)
 }
 
 func (rh *RequestServer) GetAthleteData(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if rh.athleteID != "" {
-		if ok, err := rh.repo.GetAthlete(w, rh.athleteID); err == nil && ok {
-			return
-		}
+	client, ok := rh.requireSession(w, req)
+	if !ok {
+		return
+	}
+	if ok, err := rh.repo.GetAthlete(w, client.AthleteID()); err == nil && ok {
+		return
 	}
 
-	var athlete *model.Athlete
-	athlete, err := rh.strava.GetAthleteData()
+	athlete, err := rh.fetchAthlete(req.Context(), client)
 	if err != nil {
 		fmt.Fprintf(w, "something went wrong: %v", err)
+		return
 	}
-	rh.athleteID = strconv.Itoa(athlete.ID)
-	rh.repo.PostAthlete(rh.athleteID, athlete.Reader())
 	athlete.Write(w)
 }
 
-func (rh *RequestServer) CollectAthleteActivities(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	sl, err := rh.strava.GetActivitySumamryList()
+// fetchAthlete loads the athlete's profile from strava and stores it
+func (rh *RequestServer) fetchAthlete(ctx context.Context, client strava.StravaClient) (*model.Athlete, error) {
+	athlete, err := client.GetAthleteData(ctx)
 	if err != nil {
-		fmt.Fprintf(w, "something went wrong: %v", err)
+		return nil, err
 	}
-	var activityID string
-	for _, sum := range sl.SumamryList {
-		fmt.Fprintf(w, "\nfetching activity '%s'..", sum.Name)
-		activityID = strconv.Itoa(sum.ID)
-		stream, err := rh.strava.GetRide(activityID)
-		if err != nil {
-			fmt.Fprintf(w, "\nerror fetching activity '%s': %v", sum.Name, err)
-		}
-		err = rh.repo.PostRide(activityID, stream.Reader())
-		if err != nil {
-			fmt.Fprintf(w, "\nerror storing activity '%s': %v\n\n", sum.Name, err)
-			continue
-		}
+	rh.repo.PostAthlete(client.AthleteID(), athlete.Reader())
+	return athlete, nil
+}
+
+// DeleteAthlete purges all data of the logged in athlete and ends the session.
+// A running collection is cancelled first.
+func (rh *RequestServer) DeleteAthlete(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	client, ok := rh.requireSession(w, req)
+	if !ok {
+		return
+	}
+	if err := rh.removeAthlete(req.Context(), client.AthleteID()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rh.endSession(w)
+	w.WriteHeader(http.StatusNoContent)
+}
 
-		// Post spots
-		sl := model.NewSpotList(stream)
-		err = rh.repo.PostMapData(activityID, sl.Reader())
-		if err != nil {
-			fmt.Fprintf(w, "\n could not store activity '%s' palces: %v\n\n", sum.Name, err)
-			continue
+// removeAthlete cancels the athlete's running collection, waits for it to stop
+// and removes the athlete's data
+func (rh *RequestServer) removeAthlete(ctx context.Context, athlete string) error {
+	if job, ok := rh.jobs.Running(athlete); ok {
+		rh.jobs.Cancel(job.ID())
+		select {
+		case <-job.Stopped():
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		fmt.Fprintf(w, "\ncompleted fetching activity '%s' \n\n", sum.Name)
 	}
 
-	fmt.Fprintf(w, "Ready colelcting activities")
+	if err := rh.repo.RemoveAthlete(athlete); err != nil {
+		return fmt.Errorf("could not remove athlete: %v", err)
+	}
+	rh.strava.Forget(athlete)
+	return nil
+}
+
+// DeleteRide removes a collected activity and its stops. Removing an activity
+// which is not stored is not an error.
+func (rh *RequestServer) DeleteRide(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	client, ok := rh.requireSession(w, req)
+	if !ok {
+		return
+	}
+	if err := rh.repo.RemoveRide(client.AthleteID(), ps.ByName("id")); err != nil {
+		http.Error(w, fmt.Sprintf("could not remove ride: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 func (rh *RequestServer) GetMapPlaces(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	client, ok := rh.requireSession(w, req)
+	if !ok {
+		return
+	}
 	// if req.Method == "OPTIONS" {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 	// 	return
 	// }
-	spots, err := rh.repo.GetAllMapPlaces()
+	spots, ok := rh.athleteSpots(w, req, client)
+	if !ok {
+		return
+	}
+	writeFormat(w, req, "places", spots)
+}
+
+// athleteSpots loads the athlete's stops, limited to the configured region
+// named by the "region" query parameter
+func (rh *RequestServer) athleteSpots(w http.ResponseWriter, req *http.Request, client strava.StravaClient) (*model.SpotList, bool) {
+	var fence geo.Geofence
+	if name := req.URL.Query().Get("region"); name != "" {
+		region, ok := rh.regions.Region(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown region '%s'", name), http.StatusBadRequest)
+			return nil, false
+		}
+		fence = region
+	}
+
+	spots, err := rh.repo.GetAllMapPlaces(client.AthleteID())
 	if err != nil {
-		fmt.Fprint(w, "failed fetching map places: %v", err)
+		http.Error(w, fmt.Sprintf("failed fetching map places: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	if fence != nil {
+		spots = spots.Within(fence)
 	}
-	spots.Write(w)
+	return spots, true
+}
+
+// GetSpots returns the ranked clusters of all stops. The number of clusters
+// and the clustering radius in meters can be set with the "limit" and "radius"
+// query parameters, "region" limits them to a configured region.
+func (rh *RequestServer) GetSpots(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	client, ok := rh.requireSession(w, req)
+	if !ok {
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	clusterer := model.Clusterer{Radius: rh.clusterRadius, MinPoints: 1}
+	limit := rh.spotsLimit
+	query := req.URL.Query()
+	if v := query.Get("radius"); v != "" {
+		radius, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(radius > 0) {
+			http.Error(w, fmt.Sprintf("invalid radius '%s'", v), http.StatusBadRequest)
+			return
+		}
+		clusterer.Radius = radius
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit '%s'", v), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	spots, ok := rh.athleteSpots(w, req, client)
+	if !ok {
+		return
+	}
+	clusters := clusterer.ClusterList(spots)
+	clusters.Top(limit)
+
+	writeFormat(w, req, "lazy-spots", clusters)
+}
+
+// GetQuota reports the Strava API usage of the current rate limit windows
+func (rh *RequestServer) GetQuota(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rh.strava.Quota())
+}
+
+// GetRegion returns the center of the configured region for the web map, or
+// 204 when activities are not filtered by region
+func (rh *RequestServer) GetRegion(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	lat, lng, ok := rh.regions.Center()
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	type center struct {
+		Lat float64 `json:"lat"`
+		Lng float64 `json:"lng"`
+	}
+	region := rh.regions.Regions[0]
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Name   string   `json:"name"`
+		Center center   `json:"center"`
+		Bounds geo.BBox `json:"bounds"`
+	}{region.Name, center{lat, lng}, region.Geofence().Bounds()})
 }
 
 func (rh *RequestServer) LoadMap(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/strava"
+)
+
+const (
+	SessionCookieName = "lazy_spots_session"
+	SessionMaxAge     = 30 * 24 * time.Hour
+
+	// The OAuth state is bound to the browser until the callback
+	StateCookieName = "lazy_spots_oauth_state"
+	StateMaxAge     = 10 * time.Minute
+	stateSize       = 32
+)
+
+// signer signs cookie values with HMAC-SHA256 so they can not be forged
+type signer struct {
+	key []byte
+}
+
+// sign returns "<value>|<expiry>.<signature>"
+func (s signer) sign(value string, expiry time.Time) string {
+	payload := value + "|" + strconv.FormatInt(expiry.Unix(), 10)
+	return payload + "." + s.mac(payload)
+}
+
+// verify returns the value of a signed, not yet expired cookie
+func (s signer) verify(signed string, now time.Time) (string, error) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", fmt.Errorf("value is not signed")
+	}
+	payload, mac := signed[:i], signed[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(s.mac(payload))) {
+		return "", fmt.Errorf("invalid signature")
+	}
+
+	i = strings.LastIndex(payload, "|")
+	if i < 0 {
+		return "", fmt.Errorf("value has no expiry")
+	}
+	expiry, err := strconv.ParseInt(payload[i+1:], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid expiry: %v", err)
+	}
+	if now.After(time.Unix(expiry, 0)) {
+		return "", fmt.Errorf("value expired")
+	}
+	return payload[:i], nil
+}
+
+func (s signer) mac(payload string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// startSession binds the browser to the athlete
+func (rh *RequestServer) startSession(w http.ResponseWriter, athlete string) {
+	expiry := time.Now().Add(SessionMaxAge)
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    rh.signer.sign(athlete, expiry),
+		Path:     "/",
+		Expires:  expiry,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (rh *RequestServer) endSession(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// session returns the strava client of the athlete logged in the browser
+func (rh *RequestServer) session(req *http.Request) (strava.StravaClient, error) {
+	cookie, err := req.Cookie(SessionCookieName)
+	if err != nil {
+		return nil, fmt.Errorf("not logged in")
+	}
+	athlete, err := rh.signer.verify(cookie.Value, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("invalid session: %v", err)
+	}
+	client, err := rh.strava.Client(athlete)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session: %v", err)
+	}
+	if !client.IsTokenValid() {
+		return nil, fmt.Errorf("session expired")
+	}
+	return client, nil
+}
+
+// newState creates a random OAuth state and stores it in a signed cookie
+func (rh *RequestServer) newState(w http.ResponseWriter) (string, error) {
+	b := make([]byte, stateSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not create state: %v", err)
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+
+	expiry := time.Now().Add(StateMaxAge)
+	http.SetCookie(w, &http.Cookie{
+		Name:     StateCookieName,
+		Value:    rh.signer.sign(state, expiry),
+		Path:     "/callback",
+		Expires:  expiry,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return state, nil
+}
+
+// consumeState checks the state of the callback against the browser's cookie.
+// The cookie is removed so a state can be used only once.
+func (rh *RequestServer) consumeState(w http.ResponseWriter, req *http.Request) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     StateCookieName,
+		Value:    "",
+		Path:     "/callback",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	cookie, err := req.Cookie(StateCookieName)
+	if err != nil {
+		return fmt.Errorf("login was not started from this browser")
+	}
+	expected, err := rh.signer.verify(cookie.Value, time.Now())
+	if err != nil {
+		return fmt.Errorf("invalid login state: %v", err)
+	}
+	state := req.URL.Query().Get("state")
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(state)) != 1 {
+		return fmt.Errorf("login state does not match")
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/IcoBoyanov/lazy-spots/strava"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Object and aspect types of webhook events
+const (
+	WebhookObjectActivity = "activity"
+	WebhookObjectAthlete  = "athlete"
+
+	WebhookCreate = "create"
+	WebhookUpdate = "update"
+	WebhookDelete = "delete"
+)
+
+// WebhookQueueSize is the number of events waiting to be processed, further
+// events are refused and redelivered by Strava
+const WebhookQueueSize = 100
+
+// WebhookEvent is an event of the push subscription
+// https://developers.strava.com/docs/webhooks/
+type WebhookEvent struct {
+	AspectType     string                 `json:"aspect_type"`
+	EventTime      int64                  `json:"event_time"`
+	ObjectID       int64                  `json:"object_id"`
+	ObjectType     string                 `json:"object_type"`
+	OwnerID        int64                  `json:"owner_id"`
+	SubscriptionID int64                  `json:"subscription_id"`
+	Updates        map[string]interface{} `json:"updates"`
+}
+
+// Deauthorized reports whether the athlete revoked the application's access
+func (e WebhookEvent) Deauthorized() bool {
+	return e.ObjectType == WebhookObjectAthlete && e.AspectType == WebhookUpdate &&
+		fmt.Sprint(e.Updates["authorized"]) == "false"
+}
+
+// SetWebhook enables the push subscription. verifyToken is the token given
+// when the subscription is created. Only events of subscriptionID are
+// accepted, with 0 the validation request is answered but all events are
+// refused, as when the subscription is being created.
+func (rh *RequestServer) SetWebhook(verifyToken string, subscriptionID int64) {
+	rh.webhookToken = verifyToken
+	rh.subscription = subscriptionID
+}
+
+// ValidateWebhook answers the validation request sent by Strava when the
+// push subscription is created
+func (rh *RequestServer) ValidateWebhook(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	query := req.URL.Query()
+	token := query.Get("hub.verify_token")
+	if rh.webhookToken == "" || query.Get("hub.mode") != "subscribe" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(rh.webhookToken)) != 1 {
+		http.Error(w, "invalid verify token", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"hub.challenge": query.Get("hub.challenge")})
+}
+
+// ReceiveWebhook queues the event and responds right away, Strava expects an
+// answer within two seconds
+func (rh *RequestServer) ReceiveWebhook(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if rh.webhookToken == "" {
+		http.Error(w, "webhook is not enabled", http.StatusNotFound)
+		return
+	}
+	var event WebhookEvent
+	if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+		http.Error(w, fmt.Sprintf("could not parse event: %v", err), http.StatusBadRequest)
+		return
+	}
+	if rh.subscription == 0 || event.SubscriptionID != rh.subscription {
+		http.Error(w, fmt.Sprintf("unknown subscription '%d'", event.SubscriptionID), http.StatusForbidden)
+		return
+	}
+
+	select {
+	case rh.webhooks <- event:
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "too many pending events", http.StatusServiceUnavailable)
+	}
+}
+
+// processWebhooks handles the queued events one at a time, so events of an
+// activity are applied in the order they were received
+func (rh *RequestServer) processWebhooks() {
+	for event := range rh.webhooks {
+		if err := rh.handleWebhookEvent(context.Background(), event); err != nil {
+			log.Printf("could not handle %s event of %s '%d': %v", event.AspectType, event.ObjectType, event.ObjectID, err)
+		}
+	}
+}
+
+// handleWebhookEvent collects created and updated activities, removes deleted
+// ones and removes all data of athletes who revoked access. Strava does not
+// sign events, so deletions are only applied once Strava confirms them.
+func (rh *RequestServer) handleWebhookEvent(ctx context.Context, event WebhookEvent) error {
+	athlete := strconv.FormatInt(event.OwnerID, 10)
+	if event.Deauthorized() {
+		return rh.removeDeauthorized(ctx, athlete)
+	}
+	if event.ObjectType != WebhookObjectActivity {
+		return nil
+	}
+
+	activityID := strconv.FormatInt(event.ObjectID, 10)
+	switch event.AspectType {
+	case WebhookCreate, WebhookUpdate:
+		return rh.ingestActivity(ctx, athlete, activityID)
+	case WebhookDelete:
+		return rh.removeDeleted(ctx, athlete, activityID)
+	}
+	return nil
+}
+
+// removeDeauthorized removes the athlete's data if Strava rejects the stored
+// token
+func (rh *RequestServer) removeDeauthorized(ctx context.Context, athlete string) error {
+	client, err := rh.strava.Client(athlete)
+	if err != nil {
+		return err
+	}
+	_, err = client.GetAthleteData(ctx)
+	if err == nil {
+		return fmt.Errorf("athlete '%s' is still authorized", athlete)
+	}
+	if err != strava.ErrUnauthorized {
+		return fmt.Errorf("could not confirm deauthorization: %v", err)
+	}
+	return rh.removeAthlete(ctx, athlete)
+}
+
+// removeDeleted removes the activity if Strava no longer has it
+func (rh *RequestServer) removeDeleted(ctx context.Context, athlete, activityID string) error {
+	client, err := rh.strava.Client(athlete)
+	if err != nil {
+		return err
+	}
+	_, err = client.GetActivity(ctx, activityID)
+	if err == nil {
+		return fmt.Errorf("activity '%s' still exists", activityID)
+	}
+	if err != strava.ErrNotFound {
+		return fmt.Errorf("could not confirm deletion: %v", err)
+	}
+	return rh.repo.RemoveRide(athlete, activityID)
+}
+
+// ingestActivity fetches the activity again and stores it, an activity which
+// is no longer in the configured regions is removed
+func (rh *RequestServer) ingestActivity(ctx context.Context, athlete, activityID string) error {
+	client, err := rh.strava.Client(athlete)
+	if err != nil {
+		return err
+	}
+	sum, err := client.GetActivity(ctx, activityID)
+	if err != nil {
+		return err
+	}
+
+	filtered := rh.regions.Filter(&model.ActivitySummaryList{SumamryList: []model.ActivitySummary{*sum}})
+	if len(filtered.SumamryList) == 0 {
+		return rh.repo.RemoveRide(athlete, activityID)
+	}
+	_, err = rh.collectActivity(ctx, client, nil, activityID, sum.StartDate)
+	return err
+}
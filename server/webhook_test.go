@@ -0,0 +1,355 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"github.com/IcoBoyanov/lazy-spots/repository"
+	"github.com/IcoBoyanov/lazy-spots/repository/memory"
+	"github.com/IcoBoyanov/lazy-spots/strava"
+)
+
+// Events as delivered by Strava, see
+// https://developers.strava.com/docs/webhooks/
+const (
+	createEvent = `{"aspect_type":"create","event_time":1549560669,"object_id":1360128428,"object_type":"activity","owner_id":134815,"subscription_id":120475,"updates":{}}`
+	updateEvent = `{"aspect_type":"update","event_time":1516126040,"object_id":1360128428,"object_type":"activity","owner_id":134815,"subscription_id":120475,"updates":{"title":"Messy"}}`
+	deleteEvent = `{"aspect_type":"delete","event_time":1549560800,"object_id":1360128428,"object_type":"activity","owner_id":134815,"subscription_id":120475,"updates":{}}`
+	deauthEvent = `{"aspect_type":"update","event_time":1516126040,"object_id":134815,"object_type":"athlete","owner_id":134815,"subscription_id":120475,"updates":{"authorized":"false"}}`
+
+	testAthlete      = "134815"
+	testActivity     = "1360128428"
+	testSubscription = 120475
+	testVerifyToken  = "STRAVA"
+)
+
+// fakeService hands out fakeClients
+type fakeService struct {
+	mu        sync.Mutex
+	clients   map[string]*fakeClient
+	forgotten []string
+}
+
+func (s *fakeService) GetAuthURL(state string) string { return "" }
+
+func (s *fakeService) Authenticate(context.Context, *url.URL) (strava.StravaClient, error) {
+	return nil, strava.ErrUnauthorized
+}
+
+func (s *fakeService) Client(athlete string) (strava.StravaClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clients[athlete]
+	if !ok {
+		return nil, strava.ErrUnauthorized
+	}
+	return client, nil
+}
+
+func (s *fakeService) Forget(athlete string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forgotten = append(s.forgotten, athlete)
+}
+
+func (s *fakeService) Quota() strava.Quota { return strava.Quota{} }
+
+// fakeClient serves the activities it holds, revoked reports the token as
+// rejected
+type fakeClient struct {
+	mu         sync.Mutex
+	athlete    string
+	revoked    bool
+	activities map[string]*model.ActivitySummary
+	streams    map[string]*model.ActivityStream
+}
+
+func (c *fakeClient) AthleteID() string  { return c.athlete }
+func (c *fakeClient) IsTokenValid() bool { return !c.revoked }
+
+func (c *fakeClient) GetAthleteData(ctx context.Context) (*model.Athlete, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.revoked {
+		return nil, strava.ErrUnauthorized
+	}
+	return &model.Athlete{}, nil
+}
+
+func (c *fakeClient) GetActivitySumamryList(ctx context.Context, before, after time.Time) (*model.ActivitySummaryList, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var list model.ActivitySummaryList
+	for _, sum := range c.activities {
+		list.SumamryList = append(list.SumamryList, *sum)
+	}
+	return &list, nil
+}
+
+func (c *fakeClient) GetActivity(ctx context.Context, id string) (*model.ActivitySummary, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.revoked {
+		return nil, strava.ErrUnauthorized
+	}
+	sum, ok := c.activities[id]
+	if !ok {
+		return nil, strava.ErrNotFound
+	}
+	return sum, nil
+}
+
+func (c *fakeClient) GetRide(ctx context.Context, id string) (*model.ActivityStream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stream, ok := c.streams[id]
+	if !ok {
+		return nil, strava.ErrNotFound
+	}
+	return stream, nil
+}
+
+func (c *fakeClient) setActivity(id string, stream *model.ActivityStream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.activities[id] = &model.ActivitySummary{StartDate: time.Date(2019, 2, 7, 17, 31, 9, 0, time.UTC)}
+	c.streams[id] = stream
+}
+
+func (c *fakeClient) removeActivity(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.activities, id)
+	delete(c.streams, id)
+}
+
+func (c *fakeClient) revoke() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revoked = true
+}
+
+// testStream rides for a minute, stands still for stop seconds and rides on
+func testStream(stop int) *model.ActivityStream {
+	var latlng model.LatLngStream
+	var times model.IntStream
+	var moving model.BoolStream
+	lat := 42.0
+	add := func(seconds int, move bool) {
+		for i := 0; i < seconds; i += 5 {
+			if move {
+				lat += 0.0002
+			}
+			latlng = append(latlng, [2]float64{lat, 23})
+			times = append(times, len(times)*5)
+			moving = append(moving, move)
+		}
+	}
+	add(60, true)
+	add(stop, false)
+	add(60, true)
+
+	var as model.ActivityStream
+	as.Add(model.StreamData{Type: model.StreamTypeLatLng, Data: latlng})
+	as.Add(model.StreamData{Type: model.StreamTypeTime, Data: times})
+	as.Add(model.StreamData{Type: model.StreamTypeMoving, Data: moving})
+	return &as
+}
+
+// newWebhookServer does not start processing, tests take the queued events
+// and handle them one at a time
+func newWebhookServer(subscription int64) (*RequestServer, *fakeService, *fakeClient, repository.Repository) {
+	client := &fakeClient{
+		athlete:    testAthlete,
+		activities: make(map[string]*model.ActivitySummary),
+		streams:    make(map[string]*model.ActivityStream),
+	}
+	service := &fakeService{clients: map[string]*fakeClient{testAthlete: client}}
+	repo := memory.New(log.New(ioutil.Discard, "", 0))
+	rh := &RequestServer{
+		strava:   service,
+		repo:     repo,
+		jobs:     NewJobManager(),
+		workers:  1,
+		webhooks: make(chan WebhookEvent, WebhookQueueSize),
+		detector: model.StopDetector{MinDuration: time.Minute},
+	}
+	rh.SetWebhook(testVerifyToken, subscription)
+	return rh, service, client, repo
+}
+
+// post delivers the payload to ReceiveWebhook and handles the queued event
+func post(t *testing.T, rh *RequestServer, payload string) (int, error) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(payload))
+	rec := httptest.NewRecorder()
+	rh.ReceiveWebhook(rec, req, nil)
+	if rec.Code != http.StatusOK {
+		return rec.Code, nil
+	}
+	select {
+	case event := <-rh.webhooks:
+		return rec.Code, rh.handleWebhookEvent(context.Background(), event)
+	default:
+		t.Fatalf("event was accepted but not queued")
+		return 0, nil
+	}
+}
+
+func hasRide(t *testing.T, repo repository.Repository) bool {
+	t.Helper()
+	ok, err := repo.GetRide(ioutil.Discard, testAthlete, testActivity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func storedSpots(t *testing.T, repo repository.Repository) []model.Spot {
+	t.Helper()
+	places, err := repo.GetAllMapPlaces(testAthlete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return places.Data
+}
+
+func TestValidateWebhook(t *testing.T) {
+	rh, _, _, _ := newWebhookServer(testSubscription)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"challenge", "hub.mode=subscribe&hub.verify_token=STRAVA&hub.challenge=15f7d1a91c1f40f8a748fd134752feb3", http.StatusOK},
+		{"wrong token", "hub.mode=subscribe&hub.verify_token=other&hub.challenge=15f7d1a91c1f40f8a748fd134752feb3", http.StatusForbidden},
+		{"wrong mode", "hub.mode=unsubscribe&hub.verify_token=STRAVA&hub.challenge=15f7d1a91c1f40f8a748fd134752feb3", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			rh.ValidateWebhook(rec, httptest.NewRequest(http.MethodGet, "/webhook?"+tt.query, nil), nil)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var body map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body["hub.challenge"] != "15f7d1a91c1f40f8a748fd134752feb3" {
+				t.Fatalf("challenge = %q", body["hub.challenge"])
+			}
+		})
+	}
+}
+
+func TestReceiveWebhookRefusesOtherSubscriptions(t *testing.T) {
+	for _, subscription := range []int64{0, 1} {
+		rh, _, _, _ := newWebhookServer(subscription)
+		if code, _ := post(t, rh, deauthEvent); code != http.StatusForbidden {
+			t.Fatalf("subscription %d: status = %d, want %d", subscription, code, http.StatusForbidden)
+		}
+	}
+
+	rh, _, _, _ := newWebhookServer(testSubscription)
+	rec := httptest.NewRecorder()
+	rh.ReceiveWebhook(rec, httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewBufferString("{")), nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid json: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestWebhookCreateUpdateDelete(t *testing.T) {
+	rh, _, client, repo := newWebhookServer(testSubscription)
+
+	client.setActivity(testActivity, testStream(120))
+	if _, err := post(t, rh, createEvent); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if !hasRide(t, repo) {
+		t.Fatalf("create: ride was not stored")
+	}
+	if spots := storedSpots(t, repo); len(spots) != 1 || spots[0].Activity != testActivity {
+		t.Fatalf("create: spots = %+v, want one of the activity", spots)
+	}
+
+	client.setActivity(testActivity, testStream(0))
+	if _, err := post(t, rh, updateEvent); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if spots := storedSpots(t, repo); len(spots) != 0 {
+		t.Fatalf("update: spots = %+v, want none", spots)
+	}
+
+	// The activity is still on Strava, the event is not trusted
+	if _, err := post(t, rh, deleteEvent); err == nil {
+		t.Fatalf("delete: expected an error while the activity exists")
+	}
+	if !hasRide(t, repo) {
+		t.Fatalf("delete: ride was removed while it exists on strava")
+	}
+
+	client.removeActivity(testActivity)
+	if _, err := post(t, rh, deleteEvent); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if hasRide(t, repo) {
+		t.Fatalf("delete: ride was not removed")
+	}
+}
+
+func TestWebhookDeauthorization(t *testing.T) {
+	rh, service, client, repo := newWebhookServer(testSubscription)
+	client.setActivity(testActivity, testStream(120))
+	if _, err := post(t, rh, createEvent); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.PostToken(testAthlete, strings.NewReader(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The token still works, the event is not trusted
+	if _, err := post(t, rh, deauthEvent); err == nil {
+		t.Fatalf("expected an error while the athlete is authorized")
+	}
+	if !hasRide(t, repo) {
+		t.Fatalf("athlete was removed while authorized")
+	}
+
+	client.revoke()
+	if _, err := post(t, rh, deauthEvent); err != nil {
+		t.Fatal(err)
+	}
+	if hasRide(t, repo) {
+		t.Fatalf("rides were not removed")
+	}
+	if ok, err := repo.GetToken(ioutil.Discard, testAthlete); err != nil || ok {
+		t.Fatalf("token was not removed: %v", err)
+	}
+	if len(service.forgotten) != 1 || service.forgotten[0] != testAthlete {
+		t.Fatalf("forgotten = %v, want [%s]", service.forgotten, testAthlete)
+	}
+}
+
+func TestWebhookUnknownAthlete(t *testing.T) {
+	rh, _, _, _ := newWebhookServer(testSubscription)
+	payload := strings.Replace(deauthEvent, `"owner_id":134815`, `"owner_id":42`, 1)
+	if _, err := post(t, rh, payload); err == nil {
+		t.Fatalf("expected an error for an athlete without a token")
+	}
+}
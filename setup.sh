@@ -3,7 +3,12 @@
 # Strava API creedntials
 export CLIENT_ID=""
 export CLIENT_SECRET=""
-export ACCESS_TOKEN=""
+
+# Signs the session cookies, keep it stable to stay logged in across restarts
+export SESSION_SECRET=""
+
+# Verify token of the strava push subscription, the webhook is off when empty
+export WEBHOOK_VERIFY_TOKEN=""
 
 # default credentials for the minio docker image
 export MINIO_ACCESS_KEY="minioadmin" 
@@ -0,0 +1,194 @@
+package strava
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrUnauthorized is returned when Strava rejects the athlete's token or
+	// refuses to refresh it, e.g. after the athlete revoked access
+	ErrUnauthorized = errors.New("strava rejected the athlete's token")
+	// ErrNotFound is returned for activities which do not exist
+	ErrNotFound = errors.New("not found on strava")
+)
+
+// StravaClient calls the Strava API on behalf of a single athlete
+type StravaClient interface {
+	AthleteID() string
+	IsTokenValid() bool
+	GetAthleteData(ctx context.Context) (*model.Athlete, error)
+	GetActivitySumamryList(ctx context.Context, before, after time.Time) (*model.ActivitySummaryList, error)
+	GetActivity(ctx context.Context, id string) (*model.ActivitySummary, error)
+	GetRide(ctx context.Context, id string) (*model.ActivityStream, error)
+}
+
+type stravaClient struct {
+	client   *http.Client
+	endpoint string
+	athlete  string
+	source   *tokenSource
+}
+
+func (c *stravaClient) AthleteID() string {
+	return c.athlete
+}
+
+// IsTokenValid reports whether the token is either still valid or can be
+// refreshed
+func (c *stravaClient) IsTokenValid() bool {
+	token := c.source.current()
+	return token.Valid() || token.RefreshToken != ""
+}
+
+func (c *stravaClient) GetAthleteData(ctx context.Context) (*model.Athlete, error) {
+	resp, err := c.get(ctx, c.endpoint+"athlete")
+	if err != nil {
+		return nil, requestError("could not get athlete data", err)
+	}
+	defer resp.Body.Close()
+	if err := statusError(resp); err != nil {
+		return nil, err
+	}
+	athlete, err := model.NewAthlete(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse body: %v", err)
+	}
+	return athlete, nil
+}
+
+// GetActivitySumamryList walks all pages of the athlete's activities started
+// between after and before. Zero times leave the range open. A rejected token
+// is ErrUnauthorized on any page.
+func (c *stravaClient) GetActivitySumamryList(ctx context.Context, before, after time.Time) (*model.ActivitySummaryList, error) {
+	var all model.ActivitySummaryList
+	for page := 1; ; page++ {
+		pageURL, err := listActivitiesURL(c.endpoint, MaxActivitiesPerPage, page, before, after)
+		if err != nil {
+			return nil, err
+		}
+		list, err := c.getActivityPage(ctx, pageURL)
+		if err == ErrUnauthorized {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not get page %d of athlete's activities: %v", page, err)
+		}
+		if len(list.SumamryList) == 0 {
+			break
+		}
+		all.SumamryList = append(all.SumamryList, list.SumamryList...)
+	}
+	return &all, nil
+}
+
+func (c *stravaClient) getActivityPage(ctx context.Context, pageURL string) (*model.ActivitySummaryList, error) {
+	resp, err := c.get(ctx, pageURL)
+	if err != nil {
+		return nil, requestError("could not request the page", err)
+	}
+	defer resp.Body.Close()
+	if err := statusError(resp); err != nil {
+		return nil, err
+	}
+	return model.NewActivitySummaryPage(resp.Body)
+}
+
+// GetActivity fetches the summary of a single activity
+func (c *stravaClient) GetActivity(ctx context.Context, id string) (*model.ActivitySummary, error) {
+	activityURL, err := activityURL(c.endpoint, id)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.get(ctx, activityURL)
+	if err != nil {
+		return nil, requestError("could not get athlete's activity", err)
+	}
+	defer resp.Body.Close()
+	if err := statusError(resp); err != nil {
+		return nil, err
+	}
+	return model.NewActivitySummary(resp.Body)
+}
+
+// GetRide fetches the moving, latlng and time streams with a single request
+func (c *stravaClient) GetRide(ctx context.Context, id string) (*model.ActivityStream, error) {
+	types := strings.Split(model.ActivityStreamTypes, ",")
+	streamURL, err := activityStreamURL(c.endpoint, id, types)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.get(ctx, streamURL)
+	if err != nil {
+		return nil, requestError("could not get athlete's activity streams", err)
+	}
+	defer resp.Body.Close()
+	if err := statusError(resp); err != nil {
+		return nil, err
+	}
+
+	var open_buff bytes.Buffer
+	open_buff.WriteString(`{"streams":`)
+	var close_buff bytes.Buffer
+	close_buff.WriteString(`}`)
+
+	stream, err := model.NewActivityStream(io.MultiReader(&open_buff, resp.Body, &close_buff))
+	if err != nil {
+		return nil, fmt.Errorf("invalid activity '%s': %v", id, err)
+	}
+
+	// Strava returns the distance stream along with the requested ones. Empty
+	// streams are dropped while decoding, a missing moving stream is derived
+	// from the track.
+	var ride model.ActivityStream
+	for _, streamType := range types {
+		if sd := stream.Stream(streamType); sd != nil {
+			if err := ride.Add(*sd); err != nil {
+				return nil, fmt.Errorf("invalid activity '%s': %v", id, err)
+			}
+		}
+	}
+	return &ride, nil
+}
+
+// statusError returns ErrUnauthorized or ErrNotFound for these statuses and an
+// error for any other status but 200
+func statusError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusNotFound:
+		return ErrNotFound
+	}
+	return fmt.Errorf("unexpected status '%s'", resp.Status)
+}
+
+// requestError returns ErrUnauthorized when the request failed because the
+// token could not be refreshed
+func requestError(msg string, err error) error {
+	var refresh *oauth2.RetrieveError
+	if errors.As(err, &refresh) && refresh.Response != nil &&
+		(refresh.Response.StatusCode == http.StatusBadRequest || refresh.Response.StatusCode == http.StatusUnauthorized) {
+		return ErrUnauthorized
+	}
+	return fmt.Errorf("%s: %v", msg, err)
+}
+
+func (c *stravaClient) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.client.Do(req)
+}
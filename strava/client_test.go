@@ -0,0 +1,288 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+)
+
+// newTestClient calls the API at server without a token
+func newTestClient(server *httptest.Server) *stravaClient {
+	return &stravaClient{
+		client:   server.Client(),
+		endpoint: server.URL + "/",
+		athlete:  "1",
+	}
+}
+
+// activityPages serves pages of the activity list, the page after the last
+// one is empty. It records the query of every request.
+type activityPages struct {
+	mu      sync.Mutex
+	pages   [][]model.ActivitySummary
+	queries []map[string]string
+}
+
+func (p *activityPages) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/athlete/activities" {
+		http.NotFound(w, req)
+		return
+	}
+	query := make(map[string]string)
+	for key := range req.URL.Query() {
+		query[key] = req.URL.Query().Get(key)
+	}
+
+	p.mu.Lock()
+	p.queries = append(p.queries, query)
+	p.mu.Unlock()
+
+	page, err := strconv.Atoi(query["page"])
+	if err != nil || page < 1 {
+		http.Error(w, "invalid page", http.StatusBadRequest)
+		return
+	}
+	result := []model.ActivitySummary{}
+	if page <= len(p.pages) {
+		result = p.pages[page-1]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func summaries(from, n int) []model.ActivitySummary {
+	result := make([]model.ActivitySummary, n)
+	for i := range result {
+		result[i] = model.ActivitySummary{ID: from + i, Name: fmt.Sprintf("ride %d", from+i)}
+	}
+	return result
+}
+
+func TestGetActivitySumamryListPages(t *testing.T) {
+	before := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	after := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		pages         [][]model.ActivitySummary
+		before, after time.Time
+		wantQuery     map[string]string
+	}{
+		{
+			name:      "full pages",
+			pages:     [][]model.ActivitySummary{summaries(1, MaxActivitiesPerPage), summaries(201, MaxActivitiesPerPage), summaries(401, 17)},
+			before:    before,
+			after:     after,
+			wantQuery: map[string]string{"per_page": "200", "before": strconv.FormatInt(before.Unix(), 10), "after": strconv.FormatInt(after.Unix(), 10)},
+		},
+		{
+			name:      "open range",
+			pages:     [][]model.ActivitySummary{summaries(1, 3), summaries(4, 2)},
+			wantQuery: map[string]string{"per_page": "200"},
+		},
+		{
+			name:      "only after",
+			pages:     [][]model.ActivitySummary{summaries(1, 3)},
+			after:     after,
+			wantQuery: map[string]string{"per_page": "200", "after": strconv.FormatInt(after.Unix(), 10)},
+		},
+		{
+			name:      "no activities",
+			wantQuery: map[string]string{"per_page": "200"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages := &activityPages{pages: tt.pages}
+			server := httptest.NewServer(pages)
+			defer server.Close()
+
+			list, err := newTestClient(server).GetActivitySumamryList(context.Background(), tt.before, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want []model.ActivitySummary
+			for _, page := range tt.pages {
+				want = append(want, page...)
+			}
+			if len(list.SumamryList) != len(want) {
+				t.Fatalf("got %d activities, want %d", len(list.SumamryList), len(want))
+			}
+			for i := range want {
+				if list.SumamryList[i].ID != want[i].ID {
+					t.Fatalf("activity %d has id %d, want %d", i, list.SumamryList[i].ID, want[i].ID)
+				}
+			}
+
+			// Every page is requested once, up to the first empty one
+			if len(pages.queries) != len(tt.pages)+1 {
+				t.Fatalf("got %d requests, want %d", len(pages.queries), len(tt.pages)+1)
+			}
+			for i, query := range pages.queries {
+				wantQuery := map[string]string{"page": strconv.Itoa(i + 1)}
+				for key, value := range tt.wantQuery {
+					wantQuery[key] = value
+				}
+				if fmt.Sprint(query) != fmt.Sprint(wantQuery) {
+					t.Errorf("request %d query = %v, want %v", i+1, query, wantQuery)
+				}
+			}
+		})
+	}
+}
+
+func TestGetActivitySumamryListFailingPage(t *testing.T) {
+	pages := &activityPages{pages: [][]model.ActivitySummary{summaries(1, MaxActivitiesPerPage)}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("page") == "2" {
+			http.Error(w, "boom", http.StatusBadGateway)
+			return
+		}
+		pages.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	if _, err := newTestClient(server).GetActivitySumamryList(context.Background(), time.Time{}, time.Time{}); err == nil {
+		t.Fatal("expected an error for a failing page")
+	}
+}
+
+// streamsWithMoving serves a ride with a three minute stop after a minute of
+// riding, and the moving stream as given
+func streamsWithMoving(moving string) http.Handler {
+	var latlng [][2]float64
+	var times, distance []float64
+	lat := 42.69
+	for i := 0; i < 300; i++ {
+		if i < 60 || i >= 240 {
+			lat += 5 / 111195.0
+		}
+		latlng = append(latlng, [2]float64{lat, 23.32})
+		times = append(times, float64(i))
+		distance = append(distance, float64(i)*5)
+	}
+	encode := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	body := fmt.Sprintf(`[
+		{"type":"latlng","data":%s,"series_type":"distance","original_size":300,"resolution":"high"},
+		{"type":"moving","data":%s,"series_type":"distance","original_size":0,"resolution":"high"},
+		{"type":"time","data":%s,"series_type":"distance","original_size":300,"resolution":"high"},
+		{"type":"distance","data":%s,"series_type":"distance","original_size":300,"resolution":"high"}
+	]`, encode(latlng), moving, encode(times), encode(distance))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/activities/1/streams" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	})
+}
+
+func TestGetRideWithoutMovingStream(t *testing.T) {
+	for _, moving := range []string{`[]`, `null`} {
+		t.Run(moving, func(t *testing.T) {
+			server := httptest.NewServer(streamsWithMoving(moving))
+			defer server.Close()
+
+			ride, err := newTestClient(server).GetRide(context.Background(), "1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ride.Moving() != nil {
+				t.Fatalf("got a moving stream of %d samples, want none", len(ride.Moving()))
+			}
+			if ride.Len() != 300 {
+				t.Fatalf("got %d samples, want 300", ride.Len())
+			}
+
+			// The stop is found by the motion classifier
+			spots := model.NewSpotList(ride).Data
+			if len(spots) != 1 {
+				t.Fatalf("got %d stops, want 1", len(spots))
+			}
+			// The speed is measured over a window, the stop is found a few
+			// seconds late
+			if spots[0].Start < 60 || spots[0].Start > 70 || spots[0].Duration < 170 || spots[0].Duration > 190 {
+				t.Errorf("stop starts at %ds and lasts %ds, want about 60s and 180s", spots[0].Start, spots[0].Duration)
+			}
+		})
+	}
+}
+
+// A revoked token is told apart from other failures on every call
+func TestClientUnauthorized(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		call   func(c *stravaClient) error
+		want   error
+	}{
+		{
+			name:   "second page of activities",
+			status: http.StatusUnauthorized,
+			call: func(c *stravaClient) error {
+				_, err := c.GetActivitySumamryList(context.Background(), time.Time{}, time.Time{})
+				return err
+			},
+			want: ErrUnauthorized,
+		},
+		{
+			name:   "activity streams",
+			status: http.StatusUnauthorized,
+			call: func(c *stravaClient) error {
+				_, err := c.GetRide(context.Background(), "1")
+				return err
+			},
+			want: ErrUnauthorized,
+		},
+		{
+			name:   "streams of a deleted activity",
+			status: http.StatusNotFound,
+			call: func(c *stravaClient) error {
+				_, err := c.GetRide(context.Background(), "1")
+				return err
+			},
+			want: ErrNotFound,
+		},
+		{
+			name:   "activity",
+			status: http.StatusUnauthorized,
+			call: func(c *stravaClient) error {
+				_, err := c.GetActivity(context.Background(), "1")
+				return err
+			},
+			want: ErrUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages := &activityPages{pages: [][]model.ActivitySummary{summaries(1, MaxActivitiesPerPage)}}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				// the first page is served, the token is rejected afterwards
+				if req.URL.Query().Get("page") == "1" {
+					pages.ServeHTTP(w, req)
+					return
+				}
+				http.Error(w, `{"message":"Authorization Error"}`, tt.status)
+			}))
+			defer server.Close()
+
+			if err := tt.call(newTestClient(server)); err != tt.want {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
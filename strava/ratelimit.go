@@ -0,0 +1,269 @@
+package strava
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Strava rate limit headers, each holding "<15 minute>,<daily>" values
+// https://developers.strava.com/docs/rate-limits/
+const (
+	RateLimitLimitHeader = "X-RateLimit-Limit"
+	RateLimitUsageHeader = "X-RateLimit-Usage"
+
+	ShortWindow = 15 * time.Minute
+)
+
+const (
+	DefaultMaxRetries  = 5
+	DefaultBaseBackoff = time.Second
+	DefaultReserve     = 5
+	maxBackoffExponent = 10
+	drainResponseLimit = 1 << 16
+)
+
+// Clock abstracts time so the rate limiter can be driven by a fake one
+type Clock interface {
+	Now() time.Time
+	After(time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+type notifyKey struct{}
+
+// WithRateLimitNotify returns a context whose requests call notify with the
+// time they are held back until, either by the rate limit or by a retry
+func WithRateLimitNotify(ctx context.Context, notify func(until time.Time)) context.Context {
+	return context.WithValue(ctx, notifyKey{}, notify)
+}
+
+func notifyRateLimited(ctx context.Context, until time.Time) {
+	if notify, ok := ctx.Value(notifyKey{}).(func(time.Time)); ok {
+		notify(until)
+	}
+}
+
+// Quota is the API usage last reported by Strava
+type Quota struct {
+	ShortLimit  int       `json:"short_limit"`
+	ShortUsage  int       `json:"short_usage"`
+	DailyLimit  int       `json:"daily_limit"`
+	DailyUsage  int       `json:"daily_usage"`
+	Updated     time.Time `json:"updated"`
+	PausedUntil time.Time `json:"paused_until"`
+}
+
+// RateLimiter is an http.RoundTripper which tracks Strava's rate limits. It
+// holds requests back once fewer than Reserve requests are left in either
+// window and retries 429 and 5xx responses with exponential backoff. Requests
+// still waiting for their response count towards the usage, so concurrent
+// callers can not use up the reserve between two responses.
+type RateLimiter struct {
+	Transport   http.RoundTripper
+	Clock       Clock
+	MaxRetries  int
+	BaseBackoff time.Duration
+	Reserve     int
+
+	mu    sync.Mutex
+	quota Quota
+	// sent requests which are not yet reflected in the quota
+	pending int
+}
+
+func NewRateLimiter(transport http.RoundTripper) *RateLimiter {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &RateLimiter{
+		Transport:   transport,
+		Clock:       realClock{},
+		MaxRetries:  DefaultMaxRetries,
+		BaseBackoff: DefaultBaseBackoff,
+		Reserve:     DefaultReserve,
+	}
+}
+
+// Quota returns the current quota state
+func (l *RateLimiter) Quota() Quota {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current(l.Clock.Now())
+}
+
+func (l *RateLimiter) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := l.wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		try := req
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("could not retry request: body can not be replayed")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("could not retry request: %v", err)
+			}
+			try = req.Clone(req.Context())
+			try.Body = body
+		}
+
+		resp, err := l.Transport.RoundTrip(try)
+		l.done(resp, err)
+		if !retryable(resp, err) || attempt >= l.MaxRetries {
+			return resp, err
+		}
+		if resp != nil {
+			io.CopyN(ioutil.Discard, resp.Body, drainResponseLimit)
+			resp.Body.Close()
+		}
+
+		delay := l.backoff(attempt)
+		notifyRateLimited(req.Context(), l.Clock.Now().Add(delay))
+		if err := l.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		// transport errors other than a cancelled request are worth a retry
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func (l *RateLimiter) backoff(attempt int) time.Duration {
+	if attempt > maxBackoffExponent {
+		attempt = maxBackoffExponent
+	}
+	return l.BaseBackoff * time.Duration(math.Pow(2, float64(attempt)))
+}
+
+// wait blocks until both windows have more than Reserve requests left and
+// counts the request as pending
+func (l *RateLimiter) wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := l.Clock.Now()
+		q := l.current(now)
+		var until time.Time
+		if q.DailyLimit > 0 && q.DailyUsage+l.pending >= q.DailyLimit-l.Reserve {
+			until = nextDailyWindow(now)
+		} else if q.ShortLimit > 0 && q.ShortUsage+l.pending >= q.ShortLimit-l.Reserve {
+			until = nextShortWindow(now)
+		}
+		l.quota.PausedUntil = until
+		if until.IsZero() {
+			l.pending++
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		notifyRateLimited(ctx, until)
+		if err := l.sleep(ctx, until.Sub(now)); err != nil {
+			l.mu.Lock()
+			l.quota.PausedUntil = time.Time{}
+			l.mu.Unlock()
+			return err
+		}
+	}
+}
+
+func (l *RateLimiter) sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.Clock.After(d):
+		return nil
+	}
+}
+
+// current returns the quota with the usage of already passed windows reset
+func (l *RateLimiter) current(now time.Time) Quota {
+	q := l.quota
+	if !q.Updated.IsZero() && !now.Before(nextShortWindow(q.Updated)) {
+		q.ShortUsage = 0
+	}
+	if !q.Updated.IsZero() && !now.Before(nextDailyWindow(q.Updated)) {
+		q.DailyUsage = 0
+	}
+	return q
+}
+
+// done takes the request off the pending ones and updates the quota with the
+// usage reported in the response. Responses may arrive out of order, so the
+// usage only grows within a window.
+func (l *RateLimiter) done(resp *http.Response, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending--
+	if err != nil {
+		return
+	}
+
+	shortLimit, dailyLimit, ok := parseRateLimitHeader(resp.Header.Get(RateLimitLimitHeader))
+	if !ok {
+		return
+	}
+	shortUsage, dailyUsage, ok := parseRateLimitHeader(resp.Header.Get(RateLimitUsageHeader))
+	if !ok {
+		return
+	}
+	now := l.Clock.Now()
+	q := l.current(now)
+	l.quota.ShortLimit, l.quota.DailyLimit = shortLimit, dailyLimit
+	l.quota.ShortUsage, l.quota.DailyUsage = max(q.ShortUsage, shortUsage), max(q.DailyUsage, dailyUsage)
+	l.quota.Updated = now
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func parseRateLimitHeader(value string) (short int, daily int, ok bool) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	short, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+	daily, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, false
+	}
+	return short, daily, true
+}
+
+// Strava's 15 minute windows start at 0, 15, 30 and 45 minutes past the hour
+func nextShortWindow(t time.Time) time.Time {
+	return t.Truncate(ShortWindow).Add(ShortWindow)
+}
+
+// The daily window resets at midnight UTC
+func nextDailyWindow(t time.Time) time.Time {
+	utc := t.UTC()
+	return time.Date(utc.Year(), utc.Month(), utc.Day()+1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,536 @@
+package strava
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+)
+
+// fakeClock fires every timer right away and moves its time forward by the
+// waited duration. With block set timers never fire.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+	block bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	if !c.block {
+		c.now = c.now.Add(d)
+		ch <- c.now
+	}
+	return ch
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) waited() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waits...)
+}
+
+// fakeAPI answers with the queued statuses, then with 200. Every response
+// reports the given usage of limits of 600 and 30000 requests.
+type fakeAPI struct {
+	mu                     sync.Mutex
+	statuses               []int
+	shortUsage, dailyUsage int
+	requests               int
+	bodies                 []string
+}
+
+func (a *fakeAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests++
+	a.bodies = append(a.bodies, string(body))
+	w.Header().Set(RateLimitLimitHeader, "600,30000")
+	w.Header().Set(RateLimitUsageHeader, fmt.Sprintf("%d,%d", a.shortUsage, a.dailyUsage))
+	status := http.StatusOK
+	if len(a.statuses) > 0 {
+		status, a.statuses = a.statuses[0], a.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (a *fakeAPI) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.requests
+}
+
+// Thursday, 10:07:30 UTC
+var testNow = time.Date(2021, 3, 4, 10, 7, 30, 0, time.UTC)
+
+func newTestLimiter(server *httptest.Server, clock *fakeClock) *RateLimiter {
+	l := NewRateLimiter(server.Client().Transport)
+	l.Clock = clock
+	return l
+}
+
+func get(t *testing.T, l *RateLimiter, ctx context.Context, url string) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := l.RoundTrip(req)
+	if err == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestParseRateLimitHeader(t *testing.T) {
+	tests := []struct {
+		value        string
+		short, daily int
+		ok           bool
+	}{
+		{"600,30000", 600, 30000, true},
+		{"12, 345", 12, 345, true},
+		{" 0 ,0 ", 0, 0, true},
+		{"", 0, 0, false},
+		{"600", 0, 0, false},
+		{"600,30000,1", 0, 0, false},
+		{"a,30000", 0, 0, false},
+		{"600,b", 0, 0, false},
+	}
+	for _, tt := range tests {
+		short, daily, ok := parseRateLimitHeader(tt.value)
+		if short != tt.short || daily != tt.daily || ok != tt.ok {
+			t.Errorf("parseRateLimitHeader(%q) = %d, %d, %v, want %d, %d, %v", tt.value, short, daily, ok, tt.short, tt.daily, tt.ok)
+		}
+	}
+}
+
+func TestRateLimiterQuota(t *testing.T) {
+	api := &fakeAPI{shortUsage: 42, dailyUsage: 1234}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	clock := &fakeClock{now: testNow}
+	l := newTestLimiter(server, clock)
+
+	if _, err := get(t, l, context.Background(), server.URL); err != nil {
+		t.Fatal(err)
+	}
+	want := Quota{ShortLimit: 600, ShortUsage: 42, DailyLimit: 30000, DailyUsage: 1234, Updated: testNow}
+	if got := l.Quota(); got != want {
+		t.Fatalf("quota = %+v, want %+v", got, want)
+	}
+
+	// Responses without the headers keep the last quota
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer plain.Close()
+	if _, err := get(t, l, context.Background(), plain.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Quota(); got != want {
+		t.Fatalf("quota = %+v, want %+v", got, want)
+	}
+}
+
+func TestRateLimiterPausesNearReserve(t *testing.T) {
+	tests := []struct {
+		name                   string
+		shortUsage, dailyUsage int
+		wantWait               time.Duration
+	}{
+		{"plenty left", 594, 29994, 0},
+		{"short window", 595, 100, 7*time.Minute + 30*time.Second},
+		{"short window exhausted", 600, 100, 7*time.Minute + 30*time.Second},
+		{"daily window", 100, 29995, 13*time.Hour + 52*time.Minute + 30*time.Second},
+		{"both windows, daily wins", 600, 30000, 13*time.Hour + 52*time.Minute + 30*time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeAPI{shortUsage: tt.shortUsage, dailyUsage: tt.dailyUsage}
+			server := httptest.NewServer(api)
+			defer server.Close()
+			clock := &fakeClock{now: testNow}
+			l := newTestLimiter(server, clock)
+
+			if _, err := get(t, l, context.Background(), server.URL); err != nil {
+				t.Fatal(err)
+			}
+			if waits := clock.waited(); len(waits) != 0 {
+				t.Fatalf("first request waited %v", waits)
+			}
+
+			var notified time.Time
+			ctx := WithRateLimitNotify(context.Background(), func(until time.Time) { notified = until })
+			if _, err := get(t, l, ctx, server.URL); err != nil {
+				t.Fatal(err)
+			}
+			waits := clock.waited()
+			if tt.wantWait == 0 {
+				if len(waits) != 0 || !notified.IsZero() {
+					t.Fatalf("waited %v, want no wait", waits)
+				}
+				return
+			}
+			if len(waits) != 1 || waits[0] != tt.wantWait {
+				t.Fatalf("waited %v, want %v", waits, tt.wantWait)
+			}
+			if !notified.Equal(testNow.Add(tt.wantWait)) {
+				t.Fatalf("notified until %v, want %v", notified, testNow.Add(tt.wantWait))
+			}
+			if api.count() != 2 {
+				t.Fatalf("got %d requests, want 2", api.count())
+			}
+		})
+	}
+}
+
+func TestRateLimiterWindowsReset(t *testing.T) {
+	api := &fakeAPI{shortUsage: 600, dailyUsage: 30000}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	clock := &fakeClock{now: testNow}
+	l := newTestLimiter(server, clock)
+
+	if _, err := get(t, l, context.Background(), server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// 10:14:59 is still in the 10:00 window
+	clock.advance(7*time.Minute + 29*time.Second)
+	if q := l.Quota(); q.ShortUsage != 600 || q.DailyUsage != 30000 {
+		t.Fatalf("quota before the window ends = %+v", q)
+	}
+	// 10:15 starts a new short window, the daily one goes on
+	clock.advance(time.Second)
+	if q := l.Quota(); q.ShortUsage != 0 || q.DailyUsage != 30000 {
+		t.Fatalf("quota in the next short window = %+v", q)
+	}
+	// Midnight UTC starts a new day
+	clock.advance(13*time.Hour + 45*time.Minute)
+	if q := l.Quota(); q.ShortUsage != 0 || q.DailyUsage != 0 {
+		t.Fatalf("quota on the next day = %+v", q)
+	}
+
+	// Nothing is held back once both windows reset
+	api.mu.Lock()
+	api.shortUsage, api.dailyUsage = 1, 1
+	api.mu.Unlock()
+	if _, err := get(t, l, context.Background(), server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if waits := clock.waited(); len(waits) != 0 {
+		t.Fatalf("waited %v after the windows reset", waits)
+	}
+}
+
+// Concurrent requests count towards the usage before Strava reports them, so
+// no more than the quota above the reserve is in flight at once
+func TestRateLimiterConcurrentRequests(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	arrived := make(chan struct{}, 20)
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		requests++
+		usage := 590 + requests
+		mu.Unlock()
+		if usage > 591 {
+			arrived <- struct{}{}
+			<-release
+		}
+		w.Header().Set(RateLimitLimitHeader, "600,30000")
+		w.Header().Set(RateLimitUsageHeader, fmt.Sprintf("%d,%d", usage, usage))
+	}))
+	defer server.Close()
+	// handlers block until released, also when the test fails early
+	defer unblock()
+	clock := &fakeClock{now: testNow, block: true}
+	l := newTestLimiter(server, clock)
+
+	// 591 of 600 are used, 4 more requests leave the reserve of 5
+	if _, err := get(t, l, context.Background(), server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const workers = 10
+	done := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			_, err := get(t, l, ctx, server.URL)
+			done <- err
+		}()
+	}
+
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < 4; i++ {
+		select {
+		case <-arrived:
+		case <-timeout:
+			t.Fatalf("%d of 4 requests were sent", i)
+		}
+	}
+	for len(clock.waited()) < workers-4 {
+		select {
+		case <-timeout:
+			t.Fatalf("%d of %d requests are held back", len(clock.waited()), workers-4)
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+	select {
+	case <-arrived:
+		t.Fatal("a request beyond the reserve was sent")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The sent requests finish, the held back ones until they are cancelled
+	unblock()
+	for i := 0; i < workers; i++ {
+		if i == 4 {
+			cancel()
+		}
+		select {
+		case err := <-done:
+			if i < 4 && err != nil {
+				t.Fatalf("sent request failed: %v", err)
+			}
+			if i >= 4 && !errors.Is(err, context.Canceled) {
+				t.Fatalf("err = %v, want %v", err, context.Canceled)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("requests did not finish")
+		}
+	}
+	if q := l.Quota(); q.ShortUsage != 595 || !q.PausedUntil.IsZero() {
+		t.Errorf("quota = %+v, want the usage of the last response", q)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending != 0 {
+		t.Errorf("%d requests still pending", l.pending)
+	}
+}
+
+func TestRateLimiterRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxRetries   int
+		wantStatus   int
+		wantRequests int
+		wantWaits    []time.Duration
+	}{
+		{"success", nil, 5, 200, 1, nil},
+		{"too many requests", []int{429, 429}, 5, 200, 3, []time.Duration{time.Second, 2 * time.Second}},
+		{"server errors", []int{500, 502, 503}, 5, 200, 4, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}},
+		{"client errors are not retried", []int{404}, 5, 404, 1, nil},
+		{"retries run out", []int{503, 503, 503, 503}, 2, 503, 3, []time.Duration{time.Second, 2 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &fakeAPI{statuses: tt.statuses}
+			server := httptest.NewServer(api)
+			defer server.Close()
+			clock := &fakeClock{now: testNow}
+			l := newTestLimiter(server, clock)
+			l.MaxRetries = tt.maxRetries
+
+			var notified []time.Time
+			ctx := WithRateLimitNotify(context.Background(), func(until time.Time) { notified = append(notified, until) })
+			resp, err := get(t, l, ctx, server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if api.count() != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", api.count(), tt.wantRequests)
+			}
+			if waits := clock.waited(); fmt.Sprint(waits) != fmt.Sprint(tt.wantWaits) {
+				t.Errorf("waited %v, want %v", waits, tt.wantWaits)
+			}
+			if len(notified) != len(tt.wantWaits) {
+				t.Errorf("notified %d times, want %d", len(notified), len(tt.wantWaits))
+			}
+		})
+	}
+}
+
+func TestRateLimiterBackoffIsCapped(t *testing.T) {
+	l := NewRateLimiter(nil)
+	if got, want := l.backoff(maxBackoffExponent+5), l.backoff(maxBackoffExponent); got != want {
+		t.Fatalf("backoff = %v, want %v", got, want)
+	}
+}
+
+func TestRateLimiterReplaysBody(t *testing.T) {
+	api := &fakeAPI{statuses: []int{503}}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	l := newTestLimiter(server, &fakeClock{now: testNow})
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewBufferString("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := l.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if fmt.Sprint(api.bodies) != "[payload payload]" {
+		t.Fatalf("bodies = %v, want the payload twice", api.bodies)
+	}
+}
+
+func TestRateLimiterCancellation(t *testing.T) {
+	tests := []struct {
+		name     string
+		api      *fakeAPI
+		requests int
+	}{
+		// The second request waits for the next window
+		{"while paused", &fakeAPI{shortUsage: 600, dailyUsage: 100}, 1},
+		// The first request waits before its retry
+		{"while backing off", &fakeAPI{statuses: []int{429, 429}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.api)
+			defer server.Close()
+			clock := &fakeClock{now: testNow, block: true}
+			l := newTestLimiter(server, clock)
+
+			if len(tt.api.statuses) == 0 {
+				if _, err := get(t, l, context.Background(), server.URL); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				_, err := get(t, l, ctx, server.URL)
+				done <- err
+			}()
+			for len(clock.waited()) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+
+			select {
+			case err := <-done:
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("err = %v, want %v", err, context.Canceled)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("request was not cancelled")
+			}
+			if tt.api.count() != tt.requests {
+				t.Fatalf("got %d requests, want %d", tt.api.count(), tt.requests)
+			}
+			if q := l.Quota(); !q.PausedUntil.IsZero() {
+				t.Fatalf("still paused until %v", q.PausedUntil)
+			}
+		})
+	}
+}
+
+// memoryTokens is a TokenRepository in memory
+type memoryTokens struct {
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func (m *memoryTokens) PostToken(athlete string, data io.Reader) error {
+	content, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens[athlete] = string(content)
+	return nil
+}
+
+func (m *memoryTokens) GetToken(out io.Writer, athlete string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.tokens[athlete]
+	if !ok {
+		return false, nil
+	}
+	_, err := io.WriteString(out, token)
+	return ok, err
+}
+
+func TestServiceCallsThroughRateLimiter(t *testing.T) {
+	api := &fakeAPI{shortUsage: 7, dailyUsage: 70}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer access" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		api.ServeHTTP(w, req)
+		io.WriteString(w, `{"id":1}`)
+	}))
+	defer server.Close()
+
+	tokens := &memoryTokens{tokens: make(map[string]string)}
+	token := model.Token{Athlete: "1", AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}
+	tokens.PostToken("1", token.Reader())
+
+	clock := &fakeClock{now: testNow}
+	service, err := newConfig("http://localhost/callback", "id", "secret", tokens, []Option{
+		WithEndpoint(server.URL + "/"),
+		WithRateLimiter(newTestLimiter(server, clock)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := service.Client("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetAthleteData(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if q := service.Quota(); q.ShortUsage != 7 || q.DailyUsage != 70 || !q.Updated.Equal(testNow) {
+		t.Fatalf("quota = %+v, want the usage of the fake api", q)
+	}
+}
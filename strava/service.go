@@ -1,17 +1,14 @@
 package strava
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
-	"os"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/IcoBoyanov/lazy-spots/model"
 	"golang.org/x/oauth2"
 )
 
@@ -19,52 +16,78 @@ const (
 	StravaAPIEndpoint = "https://www.strava.com/api/v3/"
 	StravaAuthURL     = "https://www.strava.com/oauth/authorize"
 	StravaTokenURL    = "https://www.strava.com/oauth/token"
-	ClientIDEnv       = "CLIENT_ID"
-	ClientSecretEnv   = "CLIENT_SECRET"
+
+	// MaxActivitiesPerPage is the largest page size accepted by Strava
+	MaxActivitiesPerPage = 200
 )
 
+// StravaService authenticates athletes and hands out a client per athlete.
+// All clients share the application's rate limit.
 type StravaService interface {
-	// Client() *http.Client
-	GetAuthURL() string
-	Authenticate(context.Context, *url.URL) error
-	IsTokenValid() bool
-	GetAthleteData() (*model.Athlete, error)
-	GetActivitySumamryList() (*model.ActivitySummaryList, error)
-	GetRide(id string) (*model.ActivityStream, error)
+	GetAuthURL(state string) string
+	Authenticate(context.Context, *url.URL) (StravaClient, error)
+	Client(athlete string) (StravaClient, error)
+	Forget(athlete string)
+	Quota() Quota
 }
 
 type stravaService struct {
-	client *http.Client
-	config *oauth2.Config
-	token  *oauth2.Token
-	state  string
+	limiter  *RateLimiter
+	endpoint string
+	config   *oauth2.Config
+	tokens   TokenRepository
+
+	clientsLock sync.Mutex
+	clients     map[string]*stravaClient
 }
 
 var configLock sync.Mutex
 var stravaServiceInstance *stravaService
 
-func NewStravaService(callbackURL string) (StravaService, error) {
+// Option changes where and how the service calls Strava, e.g. to call a fake
+// API with a fake clock in tests
+type Option func(*stravaService)
+
+// WithEndpoint calls the API at endpoint, which ends with a slash, instead of
+// StravaAPIEndpoint
+func WithEndpoint(endpoint string) Option {
+	return func(s *stravaService) { s.endpoint = endpoint }
+}
+
+// WithTokenURL refreshes and exchanges tokens at tokenURL instead of
+// StravaTokenURL
+func WithTokenURL(tokenURL string) Option {
+	return func(s *stravaService) { s.config.Endpoint.TokenURL = tokenURL }
+}
+
+// WithRateLimiter sends all requests through limiter instead of one with the
+// default transport and the real clock
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(s *stravaService) { s.limiter = limiter }
+}
+
+// NewStravaService creates the service, athletes' tokens are stored in and
+// restored from tokens
+func NewStravaService(callbackURL, clientID, clientSecret string, tokens TokenRepository, options ...Option) (StravaService, error) {
 	configLock.Lock()
 	defer configLock.Unlock()
 
 	if stravaServiceInstance == nil {
-		return newConfig(callbackURL)
+		return newConfig(callbackURL, clientID, clientSecret, tokens, options)
 	}
 	return stravaServiceInstance, nil
 }
 
-func newConfig(callbackURL string) (StravaService, error) {
-	clientID, ok := os.LookupEnv(ClientIDEnv)
-	if !ok {
-		return nil, fmt.Errorf("missing value for '%s'", ClientIDEnv)
+func newConfig(callbackURL, clientID, clientSecret string, tokens TokenRepository, options []Option) (StravaService, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("missing strava client id or secret")
 	}
 
-	clientSecret, ok := os.LookupEnv(ClientSecretEnv)
-	if !ok {
-		return nil, fmt.Errorf("missing value for '%s'", ClientSecretEnv)
-	}
-
-	return &stravaService{
+	s := &stravaService{
+		endpoint: StravaAPIEndpoint,
+		limiter:  NewRateLimiter(http.DefaultTransport),
+		tokens:   tokens,
+		clients:  make(map[string]*stravaClient),
 		config: &oauth2.Config{
 			ClientID:     clientID,
 			ClientSecret: clientSecret,
@@ -75,125 +98,149 @@ func newConfig(callbackURL string) (StravaService, error) {
 				TokenURL: StravaTokenURL,
 			},
 		},
-		state: "state", // random per each client?
-	}, nil
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s, nil
 }
 
-func (s *stravaService) Authenticate(ctx context.Context, callbackURL *url.URL) error {
-	state := callbackURL.Query().Get("state")
-	if s.state != state {
-		return fmt.Errorf("state does not match: expected '%s', but got '%s'", s.state, state)
-
+// Authenticate exchanges the code of the callback URL for a token. The state
+// must be verified by the caller.
+func (s *stravaService) Authenticate(ctx context.Context, callbackURL *url.URL) (StravaClient, error) {
+	if reason := callbackURL.Query().Get("error"); reason != "" {
+		return nil, fmt.Errorf("authorization failed: %s", reason)
 	}
 	code := callbackURL.Query().Get("code")
 	if code == "" {
-		return fmt.Errorf("code is missing")
+		return nil, fmt.Errorf("code is missing")
 
 	}
 
-	configLock.Lock()
-	defer configLock.Unlock()
-	var err error
-	s.token, err = s.config.Exchange(ctx, code)
+	token, err := s.config.Exchange(ctx, code)
 	if err != nil {
-		return fmt.Errorf("could not fetch token: %v", err)
+		return nil, fmt.Errorf("could not fetch token: %v", err)
+	}
+	athlete, err := tokenAthlete(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveToken(s.tokens, athlete, token); err != nil {
+		return nil, err
 	}
-	s.client = s.config.Client(ctx, s.token)
-	return nil
-}
-
-func (s *stravaService) GetAuthURL() string {
-	return s.config.AuthCodeURL(s.state, oauth2.AccessTypeOffline)
-}
 
-func (s *stravaService) Client() *http.Client {
-	return s.client
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	client := s.newClient(athlete, token)
+	s.clients[athlete] = client
+	return client, nil
 }
 
-func (s *stravaService) IsTokenValid() bool {
-	return s.token.Valid()
-}
+// Client returns the athlete's client, restoring the token from the
+// repository after a restart
+func (s *stravaService) Client(athlete string) (StravaClient, error) {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	if client, ok := s.clients[athlete]; ok {
+		return client, nil
+	}
 
-func (s *stravaService) GetAthleteData() (*model.Athlete, error) {
-	resp, err := s.client.Get(fmt.Sprintf("%s/%s", StravaAPIEndpoint, "/athlete"))
+	token, err := loadToken(s.tokens, athlete)
 	if err != nil {
-		return nil, fmt.Errorf("could not get athlete data: %v", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-	athlete, err := model.NewAthlete(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("could not parse body: %v", err)
+	if token == nil {
+		return nil, fmt.Errorf("no token for athlete '%s'", athlete)
 	}
-	return athlete, nil
+	client := s.newClient(athlete, toOAuth2Token(token))
+	s.clients[athlete] = client
+	return client, nil
 }
 
-func (s *stravaService) GetActivitySumamryList() (*model.ActivitySummaryList, error) {
-	resp, err := s.client.Get(fmt.Sprintf("%s/%s", StravaAPIEndpoint, "/athlete/activities"))
-	if err != nil {
-		return nil, fmt.Errorf("could not get athlete's activity stream: %v", err)
-	}
-	defer resp.Body.Close()
-	var open_buff bytes.Buffer
-	open_buff.WriteString(`{"summary-list":`)
-	var close_buff bytes.Buffer
-	close_buff.WriteString(`}`)
-	return model.NewActivitySummaryList(io.MultiReader(&open_buff, resp.Body, &close_buff))
+// Forget drops the athlete's cached client, the next Client call restores it
+// from the repository
+func (s *stravaService) Forget(athlete string) {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	delete(s.clients, athlete)
 }
 
-func (s *stravaService) GetRide(id string) (*model.ActivityStream, error) {
-	respMoving, err := s.client.Get(fmt.Sprintf("%s/activities/%s/streams?keys=%s&key_by_type=", StravaAPIEndpoint, id, "moving"))
-	if err != nil {
-		return nil, fmt.Errorf("could not get athlete's moving activity stream: %v", err)
+// newClient creates the API client for the athlete's token. Refreshed tokens
+// are written back to the repository.
+func (s *stravaService) newClient(athlete string, token *oauth2.Token) *stravaClient {
+	// The client outlives the callback request, refresh the token and call
+	// the API through the rate limiter instead of the request context
+	apiCtx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: s.limiter})
+	base := oauth2.ReuseTokenSource(token, s.config.TokenSource(apiCtx, token))
+	source := newTokenSource(base, s.tokens, athlete, token)
+	return &stravaClient{
+		client:   oauth2.NewClient(apiCtx, source),
+		endpoint: s.endpoint,
+		athlete:  athlete,
+		source:   source,
 	}
-	defer respMoving.Body.Close()
+}
 
-	respLatLng, err := s.client.Get(fmt.Sprintf("%s/activities/%s/streams?keys=%s&key_by_type=", StravaAPIEndpoint, id, "latlng"))
-	if err != nil {
-		return nil, fmt.Errorf("could not get athlete's moving activity stream: %v", err)
-	}
-	defer respLatLng.Body.Close()
+func (s *stravaService) GetAuthURL(state string) string {
+	return s.config.AuthCodeURL(state, oauth2.AccessTypeOffline)
+}
 
-	var open_buff bytes.Buffer
-	open_buff.WriteString(`{"streams":`)
-	var close_buff bytes.Buffer
-	close_buff.WriteString(`}`)
+func (s *stravaService) Quota() Quota {
+	return s.limiter.Quota()
+}
 
-	moving, err := model.NewActivityStream(io.MultiReader(&open_buff, respMoving.Body, &close_buff))
+func ActivityURL(activity string) (string, error) {
+	return activityURL(StravaAPIEndpoint, activity)
+}
 
-	open_buff.WriteString(`{"streams":`)
-	close_buff.WriteString(`}`)
-	latlng, err := model.NewActivityStream(io.MultiReader(&open_buff, respLatLng.Body, &close_buff))
-	return &model.ActivityStream{
-		Streams: []model.StreamData{
-			moving.Streams[0],
-			latlng.Streams[0],
-		},
-	}, nil
+func activityURL(endpoint string, activity string) (string, error) {
+	activityURL, err := url.Parse(endpoint + "activities/" + activity)
+	if err != nil {
+		return "", fmt.Errorf("could not create activity url: %v", err)
+	}
+	return activityURL.String(), nil
 }
 
 func ActivityStreamURL(activity string, types []string) (string, error) {
-	activityStreamURL, err := url.Parse(StravaAPIEndpoint + "activities/" + activity + "/streams")
+	return activityStreamURL(StravaAPIEndpoint, activity, types)
+}
+
+// activityStreamURL requests all types at once, Strava expects them as a
+// comma separated list
+func activityStreamURL(endpoint string, activity string, types []string) (string, error) {
+	activityStreamURL, err := url.Parse(endpoint + "activities/" + activity + "/streams")
 	if err != nil {
 		return "", fmt.Errorf("could not create activity url: %v", err)
 	}
 
 	query := activityStreamURL.Query()
-	for _, t := range types {
-		query.Add("keys", t)
-	}
+	query.Set("keys", strings.Join(types, ","))
+	query.Set("key_by_type", "false")
 	activityStreamURL.RawQuery = query.Encode()
 	return activityStreamURL.String(), nil
 }
 
 func ListActivitiesURL(per_page int, page int, before time.Time, after time.Time) (string, error) {
-	// TODO fetch all pages
-	activityListURL, err := url.Parse(StravaAPIEndpoint + "athlete/activities")
+	return listActivitiesURL(StravaAPIEndpoint, per_page, page, before, after)
+}
+
+func listActivitiesURL(endpoint string, per_page int, page int, before time.Time, after time.Time) (string, error) {
+	activityListURL, err := url.Parse(endpoint + "athlete/activities")
 	if err != nil {
 		return "", fmt.Errorf("could not create activity list url: %v", err)
 	}
 
 	query := activityListURL.Query()
 	query.Set("per_page", fmt.Sprintf("%d", per_page))
+	if page > 0 {
+		query.Set("page", fmt.Sprintf("%d", page))
+	}
+	if !before.IsZero() {
+		query.Set("before", fmt.Sprintf("%d", before.Unix()))
+	}
+	if !after.IsZero() {
+		query.Set("after", fmt.Sprintf("%d", after.Unix()))
+	}
 	activityListURL.RawQuery = query.Encode()
 	return activityListURL.String(), nil
 }
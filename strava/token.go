@@ -0,0 +1,113 @@
+package strava
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/IcoBoyanov/lazy-spots/model"
+	"golang.org/x/oauth2"
+)
+
+// TokenRepository persists OAuth tokens so sessions survive restarts. It is
+// satisfied by repository.Repository.
+type TokenRepository interface {
+	PostToken(athlete string, data io.Reader) error
+	GetToken(io.Writer, string) (bool, error)
+}
+
+// tokenSource hands out tokens from base and writes refreshed ones back to
+// the repository
+type tokenSource struct {
+	mu      sync.Mutex
+	base    oauth2.TokenSource
+	repo    TokenRepository
+	athlete string
+	token   *oauth2.Token
+}
+
+func newTokenSource(base oauth2.TokenSource, repo TokenRepository, athlete string, token *oauth2.Token) *tokenSource {
+	return &tokenSource{
+		base:    base,
+		repo:    repo,
+		athlete: athlete,
+		token:   token,
+	}
+}
+
+func (ts *tokenSource) Token() (*oauth2.Token, error) {
+	token, err := ts.base.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token != nil && ts.token.AccessToken == token.AccessToken {
+		return token, nil
+	}
+	// Strava rotates refresh tokens, the token is only remembered once it is
+	// stored so that a failed save is tried again on the next call
+	if err := saveToken(ts.repo, ts.athlete, token); err != nil {
+		return nil, err
+	}
+	ts.token = token
+	return token, nil
+}
+
+// current returns the last token without refreshing it
+func (ts *tokenSource) current() *oauth2.Token {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.token
+}
+
+func saveToken(repo TokenRepository, athlete string, token *oauth2.Token) error {
+	t := model.Token{
+		Athlete:      athlete,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		TokenType:    token.TokenType,
+		Expiry:       token.Expiry,
+	}
+	if err := repo.PostToken(athlete, t.Reader()); err != nil {
+		return fmt.Errorf("could not store token: %v", err)
+	}
+	return nil
+}
+
+func loadToken(repo TokenRepository, athlete string) (*model.Token, error) {
+	var buf bytes.Buffer
+	ok, err := repo.GetToken(&buf, athlete)
+	if err != nil {
+		return nil, fmt.Errorf("could not load token: %v", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return model.NewToken(&buf)
+}
+
+// tokenAthlete reads the athlete Strava returns along with a new token
+func tokenAthlete(token *oauth2.Token) (string, error) {
+	athlete, ok := token.Extra("athlete").(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("token response is missing the athlete")
+	}
+	id, ok := athlete["id"].(float64)
+	if !ok {
+		return "", fmt.Errorf("token response is missing the athlete id")
+	}
+	return strconv.FormatInt(int64(id), 10), nil
+}
+
+func toOAuth2Token(t *model.Token) *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		TokenType:    t.TokenType,
+		Expiry:       t.Expiry,
+	}
+}
@@ -0,0 +1,58 @@
+package strava
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// failingTokens fails the given number of saves before storing tokens
+type failingTokens struct {
+	memoryTokens
+	fails int
+}
+
+func (f *failingTokens) PostToken(athlete string, data io.Reader) error {
+	if f.fails > 0 {
+		f.fails--
+		return fmt.Errorf("repository is down")
+	}
+	return f.memoryTokens.PostToken(athlete, data)
+}
+
+func TestTokenSourceRetriesFailedSave(t *testing.T) {
+	old := &oauth2.Token{AccessToken: "old", RefreshToken: "r1", Expiry: time.Now().Add(-time.Minute)}
+	refreshed := &oauth2.Token{AccessToken: "new", RefreshToken: "r2", Expiry: time.Now().Add(time.Hour)}
+	repo := &failingTokens{memoryTokens: memoryTokens{tokens: make(map[string]string)}, fails: 1}
+	ts := newTokenSource(oauth2.StaticTokenSource(refreshed), repo, "1", old)
+
+	if _, err := ts.Token(); err == nil {
+		t.Fatal("expected the failed save to fail the call")
+	}
+	if ts.current() != old {
+		t.Fatalf("current token = %+v, want the old one until the new one is stored", ts.current())
+	}
+
+	token, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.RefreshToken != "r2" || ts.current() != refreshed {
+		t.Fatalf("token = %+v, want the refreshed one", token)
+	}
+	var buf bytes.Buffer
+	if ok, err := repo.GetToken(&buf, "1"); err != nil || !ok {
+		t.Fatalf("token was not stored: %v", err)
+	}
+	stored, err := loadToken(repo, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RefreshToken != "r2" {
+		t.Fatalf("stored refresh token = %q, want r2", stored.RefreshToken)
+	}
+}
@@ -1,15 +1,23 @@
 
-const sofiaCenter = { lat: 42.6893643, lng: 23.3255209 };
+const worldCenter = { lat: 0, lng: 0 };
 let map = {}
 let markers = {};
 
-function initMap() {
+async function initMap() {
     $("#map").empty();
     markers = {};
     map = new google.maps.Map(document.getElementById("map"), {
-      zoom: 14,
-      center: sofiaCenter,
+      zoom: 2,
+      center: worldCenter,
     });
+
+    // center the map on the configured region
+    const response = await fetch("/region").catch(error => null);
+    if (response && response.status === 200) {
+        const region = await response.json();
+        const [west, south, east, north] = region.bounds;
+        map.fitBounds({ west, south, east, north });
+    }
   }
 
   $("#laod").on("click", function() {
@@ -17,7 +25,7 @@ function initMap() {
   });
 let marker = {}
 async function loadStavaPlaces() {
-    data = await fetch("http://localhost:8888/places", {
+    data = await fetch("/places", {
         // mode: 'no-cors',
     })
     .then(response => response.text())
@@ -34,3 +42,65 @@ async function loadStavaPlaces() {
     }
 
 }
+
+async function loadTopSpots() {
+    data = await fetch("/spots?limit=10")
+    .then(response => response.json())
+    .catch(error => { return { "data": [] } });
+
+    data.data.forEach((spot, i) => {
+        new google.maps.Marker({
+            position: { lat: spot.lat, lng: spot.lng },
+            map,
+            label: `${i + 1}`,
+            title: `${spot.visits} visits, ${Math.round(spot.dwell / 60)} min`,
+        });
+    });
+}
+
+async function collectActivities() {
+    const response = await fetch("/jobs/collect", { method: "POST" });
+    if (response.status !== 202 && response.status !== 409) {
+        $("#progress-text").text(`could not start collecting: ${response.status}`);
+        return;
+    }
+
+    // Both the started and the already running job are in Location
+    let id = (response.headers.get("Location") || "").split("/").pop();
+    if (!id) {
+        id = await response.json().then(job => job.id).catch(() => "");
+    }
+    const events = new EventSource(`/collect/events?job=${encodeURIComponent(id)}`);
+    const progress = (e) => {
+        const data = JSON.parse(e.data);
+        $("#progress").attr("max", data.total).val(data.done);
+        $("#progress-text").text(`${data.done} / ${data.total}`);
+        return data;
+    };
+
+    events.addEventListener("activity_started", progress);
+    events.addEventListener("activity_stored", progress);
+    events.addEventListener("activity_failed", e => {
+        const data = progress(e);
+        console.log(`activity ${data.activity} failed: ${data.error}`);
+    });
+    events.addEventListener("rate_limited", e => {
+        const data = progress(e);
+        $("#progress-text").text(`rate limited until ${new Date(data.until).toLocaleTimeString()}`);
+    });
+    events.addEventListener("spots_extracted", e => {
+        const data = progress(e);
+        for (let spot of data.spots || []) {
+            new google.maps.Marker({
+                position: { lat: spot.lat, lng: spot.lng },
+                map,
+                title: "",
+            });
+        }
+    });
+    events.addEventListener("finished", e => {
+        const data = progress(e);
+        $("#progress-text").text(`collection ${data.state}: ${data.done} / ${data.total}`);
+        events.close();
+    });
+}